@@ -0,0 +1,224 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Keycounts should only creep upwards.  A server which loses a chunk of its
+// keys has probably had its database rebuilt or truncated; one which gains
+// far more than the mesh has is miscounting; one which bounces between counts
+// is likely several backends behind one name.  And if the whole mesh appears
+// to shrink, it's more likely that our scan is broken than the mesh.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type AnomalySeverity string
+
+const (
+	SeverityInfo     AnomalySeverity = "info"
+	SeverityWarning  AnomalySeverity = "warning"
+	SeverityCritical AnomalySeverity = "critical"
+)
+
+var severityRank = map[AnomalySeverity]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+// AtLeast is whether this severity is as bad as `other`; unknown severities
+// are treated as info.
+func (s AnomalySeverity) AtLeast(other AnomalySeverity) bool {
+	return severityRank[s] >= severityRank[other]
+}
+
+type AnomalyKind string
+
+const (
+	AnomalyKeycountDrop AnomalyKind = "keycount_drop"
+	AnomalyKeycountJump AnomalyKind = "keycount_jump"
+	AnomalyKeycountFlap AnomalyKind = "keycount_flap"
+	AnomalyMeshDrop     AnomalyKind = "mesh_reference_drop"
+)
+
+type AnomalyEvent struct {
+	Time     time.Time       `json:"time"`
+	Kind     AnomalyKind     `json:"kind"`
+	Severity AnomalySeverity `json:"severity"`
+	Hostname string          `json:"hostname,omitempty"` // empty for mesh-wide events
+	Previous int             `json:"previous"`
+	Current  int             `json:"current"`
+	Message  string          `json:"message"`
+}
+
+func (e *AnomalyEvent) String() string {
+	if e.Hostname == "" {
+		return fmt.Sprintf("%s %s: %s", e.Severity, e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s %s %s: %s", e.Severity, e.Kind, e.Hostname, e.Message)
+}
+
+const maxRecentAnomalies = 500
+
+var (
+	recentAnomalies       []*AnomalyEvent
+	recentAnomaliesLock   sync.RWMutex
+	previousMeshReference int // guarded by keycountHistoryLock
+)
+
+func percentOf(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// flapping counts how often a server's keycount reversed direction by more
+// than the daily jitter, within the samples we hold.
+func flapping(samples []keycountSample, jitter int) int {
+	reversals, lastSign := 0, 0
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i].Keycount - samples[i-1].Keycount
+		sign := 0
+		switch {
+		case delta > jitter:
+			sign = 1
+		case delta < -jitter:
+			sign = -1
+		default:
+			continue
+		}
+		if lastSign != 0 && sign != lastSign {
+			reversals++
+		}
+		lastSign = sign
+	}
+	return reversals
+}
+
+// hostAnomalies judges the latest of a server's samples against the one
+// before it, and the whole window for flapping.
+func hostAnomalies(hostname string, samples []keycountSample) []*AnomalyEvent {
+	if len(samples) < 2 {
+		return nil
+	}
+	prev, cur := samples[len(samples)-2], samples[len(samples)-1]
+	event := func(kind AnomalyKind, severity AnomalySeverity, format string, v ...interface{}) *AnomalyEvent {
+		return &AnomalyEvent{
+			Time:     cur.When,
+			Kind:     kind,
+			Severity: severity,
+			Hostname: hostname,
+			Previous: prev.Keycount,
+			Current:  cur.Keycount,
+			Message:  fmt.Sprintf(format, v...),
+		}
+	}
+
+	var events []*AnomalyEvent
+	delta := cur.Keycount - prev.Keycount
+	switch {
+	case -delta > *flKeysDailyJitter && percentOf(-delta, prev.Keycount) >= *flAnomalyDropPct:
+		severity := SeverityWarning
+		if cur.Keycount < *flKeysSanityMin {
+			severity = SeverityCritical
+		}
+		events = append(events, event(AnomalyKeycountDrop, severity,
+			"lost %d keys (%.1f%%) since the previous scan", -delta, percentOf(-delta, prev.Keycount)))
+	case delta > *flKeysDailyJitter && percentOf(delta, prev.Keycount) >= *flAnomalyJumpPct &&
+		cur.Keycount > cur.Reference+*flKeysDailyJitter:
+		// Catching up to the mesh is fine; overshooting it is not.
+		events = append(events, event(AnomalyKeycountJump, SeverityWarning,
+			"gained %d keys (%.1f%%) since the previous scan, now %d beyond the mesh reference",
+			delta, percentOf(delta, prev.Keycount), cur.Keycount-cur.Reference))
+	}
+	if reversals := flapping(samples, *flKeysDailyJitter); reversals >= 2 {
+		events = append(events, event(AnomalyKeycountFlap, SeverityInfo,
+			"keycount changed direction %d times in the last %d scans", reversals, len(samples)))
+	}
+	return events
+}
+
+// DetectKeycountAnomalies must run after RecordKeycountHistory for the same
+// scan.  It fills in the scan's Anomalies and MeshAnomaly, logs each event,
+// and keeps them for a while in memory.
+func DetectKeycountAnomalies(persisted *PersistedHostInfo) {
+	var events []*AnomalyEvent
+
+	keycountHistoryLock.Lock()
+	for _, hostname := range persisted.Sorted {
+		if NodeHealthy(persisted.HostMap[hostname]) {
+			events = append(events, hostAnomalies(hostname, keycountHistory[hostname])...)
+		}
+	}
+	previous, current := previousMeshReference, persisted.KeycountReference
+	if current > 0 {
+		previousMeshReference = current
+	}
+	keycountHistoryLock.Unlock()
+
+	if previous > 0 && current < previous && percentOf(previous-current, previous) >= *flMeshDropPct {
+		event := &AnomalyEvent{
+			Time:     persisted.Timestamp,
+			Kind:     AnomalyMeshDrop,
+			Severity: SeverityCritical,
+			Previous: previous,
+			Current:  current,
+			Message: fmt.Sprintf("mesh reference keycount fell %.1f%% since the previous scan",
+				percentOf(previous-current, previous)),
+		}
+		events = append(events, event)
+		persisted.MeshAnomaly = event.Message
+	}
+
+	persisted.Anomalies = events
+	if len(events) == 0 {
+		return
+	}
+	for _, e := range events {
+		Log.Printf("Keycount anomaly: %s", e)
+	}
+	recentAnomaliesLock.Lock()
+	defer recentAnomaliesLock.Unlock()
+	recentAnomalies = append(recentAnomalies, events...)
+	if len(recentAnomalies) > maxRecentAnomalies {
+		recentAnomalies = recentAnomalies[len(recentAnomalies)-maxRecentAnomalies:]
+	}
+}
+
+// RecentAnomalies returns the events kept in memory, oldest first, for the
+// given host (all if empty) at or above the given severity.
+func RecentAnomalies(hostname string, minimum AnomalySeverity) []*AnomalyEvent {
+	recentAnomaliesLock.RLock()
+	defer recentAnomaliesLock.RUnlock()
+	return filterAnomalies(recentAnomalies, hostname, minimum)
+}
+
+func filterAnomalies(events []*AnomalyEvent, hostname string, minimum AnomalySeverity) []*AnomalyEvent {
+	matched := make([]*AnomalyEvent, 0, len(events))
+	for _, e := range events {
+		if hostname != "" && e.Hostname != hostname {
+			continue
+		}
+		if e.Severity.AtLeast(minimum) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
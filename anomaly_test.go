@@ -0,0 +1,106 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func samplesOf(reference int, counts ...int) []keycountSample {
+	samples := make([]keycountSample, len(counts))
+	for i, c := range counts {
+		samples[i] = keycountSample{Keycount: c, Reference: reference}
+	}
+	return samples
+}
+
+func TestHostAnomalies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		samples  []keycountSample
+		kinds    []AnomalyKind
+		severity AnomalySeverity
+	}{
+		{"steady growth", samplesOf(5001000, 5000000, 5000500, 5001000), nil, ""},
+		{"jitter", samplesOf(5000000, 5000000, 4999500, 5000000), nil, ""},
+		{"rebuilt", samplesOf(5000000, 5000000, 4000000), []AnomalyKind{AnomalyKeycountDrop}, SeverityCritical},
+		{"truncated a little", samplesOf(5000000, 5000000, 4900000), []AnomalyKind{AnomalyKeycountDrop}, SeverityWarning},
+		{"catching up", samplesOf(5000000, 4800000, 4999000), nil, ""},
+		{"overshoot", samplesOf(5000000, 5000000, 5200000), []AnomalyKind{AnomalyKeycountJump}, SeverityWarning},
+		{"flapping", samplesOf(5000000, 5000000, 5030000, 5000000, 5030000),
+			[]AnomalyKind{AnomalyKeycountFlap}, SeverityInfo},
+		{"single sample", samplesOf(5000000, 4000000), nil, ""},
+	} {
+		events := hostAnomalies("a.example.org", tc.samples)
+		if len(events) != len(tc.kinds) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.kinds, events)
+			continue
+		}
+		for i, e := range events {
+			if e.Kind != tc.kinds[i] || e.Severity != tc.severity {
+				t.Errorf("%s: expected %s %s, got %s", tc.name, tc.severity, tc.kinds[i], e)
+			}
+		}
+	}
+}
+
+func TestDetectMeshDrop(t *testing.T) {
+	if Log == nil {
+		Log = log.New(ioutil.Discard, "", 0)
+	}
+	keycountHistoryLock.Lock()
+	keycountHistory = make(map[string][]keycountSample)
+	previousMeshReference = 0
+	keycountHistoryLock.Unlock()
+
+	scan := func(counts ...int) *PersistedHostInfo {
+		hostMap := make(HostMap, len(counts))
+		for i, c := range counts {
+			name := string(rune('a'+i)) + ".example.org"
+			hostMap[name] = &SksNode{Hostname: name, Keycount: c}
+		}
+		persisted := &PersistedHostInfo{
+			HostMap:   hostMap,
+			Sorted:    GenerateHostlistSorted(hostMap),
+			Timestamp: time.Now(),
+		}
+		RecordKeycountHistory(persisted)
+		DetectKeycountAnomalies(persisted)
+		return persisted
+	}
+
+	if p := scan(5000000, 5000000, 5000000); p.MeshAnomaly != "" || len(p.Anomalies) != 0 {
+		t.Errorf("first scan: unexpected anomalies %q %v", p.MeshAnomaly, p.Anomalies)
+	}
+	if p := scan(5000100, 5000100, 5000100); p.MeshAnomaly != "" {
+		t.Errorf("mesh grew: unexpected mesh anomaly %q", p.MeshAnomaly)
+	}
+	p := scan(5000100, 4000000, 4000000)
+	if p.MeshAnomaly == "" {
+		t.Error("mesh median fell 20%: expected a mesh anomaly")
+	}
+	critical := filterAnomalies(p.Anomalies, "", SeverityCritical)
+	if len(critical) != 3 {
+		t.Errorf("expected two server drops and the mesh drop as critical, got %v", critical)
+	}
+	if got := filterAnomalies(p.Anomalies, "b.example.org", SeverityInfo); len(got) != 1 || got[0].Kind != AnomalyKeycountDrop {
+		t.Errorf("b.example.org: expected one drop, got %v", got)
+	}
+}
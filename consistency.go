@@ -0,0 +1,342 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Keycounts can agree while the keys don't.  After each scan, we fetch the
+// same keys from every healthy server and break each into its parts: user
+// IDs, subkeys, and the signatures on each.  A part which most servers have
+// is expected; we report, per server, what it lacks and what it has extra.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ComponentUID        = "uid"
+	ComponentUserAttr   = "user_attribute"
+	ComponentSubkey     = "subkey"
+	ComponentSignature  = "signature"
+	ComponentRevocation = "revocation"
+
+	// how many of the worst servers to highlight
+	consistencyHighlight = 5
+)
+
+func packetDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signatureType handles v3 (type at offset 2) and later (offset 1).
+func signatureType(body []byte) int {
+	switch {
+	case len(body) > 2 && body[0] == 3:
+		return int(body[2])
+	case len(body) > 1:
+		return int(body[1])
+	}
+	return -1
+}
+
+// keyComponents breaks the first key in the packets into parts, each with an
+// ID which is the same wherever the part came from, mapped to its kind.
+func keyComponents(data []byte) (map[string]string, error) {
+	packets, err := splitPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) == 0 || packets[0].tag != packetTagPublicKey {
+		return nil, fmt.Errorf("does not start with a public key packet")
+	}
+	components := make(map[string]string, len(packets))
+	parent := "primary"
+	for _, packet := range packets[1:] {
+		digest := packetDigest(packet.body)
+		switch packet.tag {
+		case packetTagPublicKey:
+			// the next key; we only asked for one
+			return components, nil
+		case packetTagUserID:
+			parent = ComponentUID + ":" + strings.ToValidUTF8(string(packet.body), "?")
+			components[parent] = ComponentUID
+		case packetTagUserAttr:
+			parent = ComponentUserAttr + ":" + digest
+			components[parent] = ComponentUserAttr
+		case packetTagPublicSubkey:
+			parent = ComponentSubkey + ":" + digest
+			components[parent] = ComponentSubkey
+		case packetTagSignature:
+			kind := ComponentSignature
+			switch signatureType(packet.body) {
+			case 0x20, 0x28, 0x30:
+				kind = ComponentRevocation
+			}
+			components[kind+" on "+parent+":"+digest] = kind
+		}
+	}
+	return components, nil
+}
+
+type ConsistencyKeyResult struct {
+	Key     string         `json:"key"`
+	Error   string         `json:"error,omitempty"`
+	Missing map[string]int `json:"missing,omitempty"` // by kind
+	Extra   map[string]int `json:"extra,omitempty"`
+}
+
+type ConsistencyServer struct {
+	Hostname   string                  `json:"hostname"`
+	Divergence int                     `json:"divergence"`
+	Highlight  bool                    `json:"highlight"`
+	Keys       []*ConsistencyKeyResult `json:"keys"` // only those which differ
+}
+
+type ConsistencyReport struct {
+	Started  time.Time            `json:"started"`
+	Finished time.Time            `json:"finished"`
+	Keys     []string             `json:"keys"`
+	Servers  []*ConsistencyServer `json:"servers"`
+}
+
+// compareKeyCopies works out, for one key, how each server's copy differs
+// from what most servers have; a nil copy is a failed fetch, with the error.
+func compareKeyCopies(fpr string, copies map[string]map[string]string, errors map[string]string) map[string]*ConsistencyKeyResult {
+	votes := make(map[string]int)
+	for _, components := range copies {
+		for id := range components {
+			votes[id]++
+		}
+	}
+	expected := make(map[string]string)
+	for _, components := range copies {
+		for id, kind := range components {
+			if votes[id]*2 > len(copies) {
+				expected[id] = kind
+			}
+		}
+	}
+
+	results := make(map[string]*ConsistencyKeyResult, len(copies)+len(errors))
+	for hostname, message := range errors {
+		result := &ConsistencyKeyResult{Key: fpr, Error: message, Missing: make(map[string]int)}
+		for _, kind := range expected {
+			result.Missing[kind]++
+		}
+		results[hostname] = result
+	}
+	for hostname, components := range copies {
+		result := &ConsistencyKeyResult{Key: fpr, Missing: make(map[string]int), Extra: make(map[string]int)}
+		for id, kind := range expected {
+			if _, ok := components[id]; !ok {
+				result.Missing[kind]++
+			}
+		}
+		for id, kind := range components {
+			if _, ok := expected[id]; !ok {
+				result.Extra[kind]++
+			}
+		}
+		results[hostname] = result
+	}
+	return results
+}
+
+func (r *ConsistencyKeyResult) divergence() int {
+	total := 0
+	for _, n := range r.Missing {
+		total += n
+	}
+	for _, n := range r.Extra {
+		total += n
+	}
+	if r.Error != "" && total == 0 {
+		total = 1
+	}
+	return total
+}
+
+// buildConsistencyReport groups the per-key results by server, worst first.
+func buildConsistencyReport(keys []string, perKey map[string]map[string]*ConsistencyKeyResult) *ConsistencyReport {
+	servers := make(map[string]*ConsistencyServer)
+	for _, fpr := range keys {
+		for hostname, result := range perKey[fpr] {
+			server, ok := servers[hostname]
+			if !ok {
+				server = &ConsistencyServer{Hostname: hostname, Keys: []*ConsistencyKeyResult{}}
+				servers[hostname] = server
+			}
+			if d := result.divergence(); d > 0 {
+				server.Divergence += d
+				server.Keys = append(server.Keys, result)
+			}
+		}
+	}
+	report := &ConsistencyReport{Keys: keys, Servers: make([]*ConsistencyServer, 0, len(servers))}
+	for _, server := range servers {
+		report.Servers = append(report.Servers, server)
+	}
+	sort.Slice(report.Servers, func(i, j int) bool {
+		if report.Servers[i].Divergence != report.Servers[j].Divergence {
+			return report.Servers[i].Divergence > report.Servers[j].Divergence
+		}
+		return hostCompare(report.Servers[i].Hostname, report.Servers[j].Hostname) < 0
+	})
+	for i, server := range report.Servers {
+		if i < consistencyHighlight && server.Divergence > 0 {
+			server.Highlight = true
+		}
+	}
+	return report
+}
+
+func consistencyKeys() []string {
+	spec := *flConsistencyKeys
+	if spec == "" {
+		spec = *flCanaryKeys
+	}
+	keys, _ := parseCanaryKeys(spec)
+	return keys
+}
+
+// CheckConsistency fetches each key from every healthy server.  Only the
+// first answering address of each server is asked: addresses of one server
+// share its key database, and asking each would multiply the load for little
+// gain.  Per-address failures are the business of the canary key lookups.
+func CheckConsistency(persisted *PersistedHostInfo, keys []string) *ConsistencyReport {
+	started := time.Now()
+	type fetched struct {
+		hostname, fpr string
+		components    map[string]string
+		err           error
+	}
+	results := make(chan *fetched, QUEUE_DEPTH)
+	limit := make(chan bool, *flIPProbeParallel)
+	var fetching sync.WaitGroup
+	go func() {
+		for hostname, node := range persisted.HostMap {
+			ips := node.AnsweringIPs()
+			if !NodeHealthy(node) || len(ips) == 0 {
+				continue
+			}
+			for _, fpr := range keys {
+				fetching.Add(1)
+				limit <- true
+				go func(hostname string, node *SksNode, ip, fpr string) {
+					defer func() { <-limit; fetching.Done() }()
+					f := &fetched{hostname: hostname, fpr: fpr}
+					body, err := hkpLookup(node.Hostname, node.Port, ip, LookupOpGet, fpr)
+					if err == nil {
+						var data []byte
+						if data, err = dearmor(string(body)); err == nil {
+							f.components, err = keyComponents(data)
+						}
+					}
+					f.err = err
+					results <- f
+				}(hostname, node, ips[0], fpr)
+			}
+		}
+		fetching.Wait()
+		close(results)
+	}()
+
+	copies := make(map[string]map[string]map[string]string, len(keys))
+	errors := make(map[string]map[string]string, len(keys))
+	for _, fpr := range keys {
+		copies[fpr] = make(map[string]map[string]string)
+		errors[fpr] = make(map[string]string)
+	}
+	for f := range results {
+		if f.err != nil {
+			errors[f.fpr][f.hostname] = f.err.Error()
+		} else {
+			copies[f.fpr][f.hostname] = f.components
+		}
+	}
+
+	perKey := make(map[string]map[string]*ConsistencyKeyResult, len(keys))
+	for _, fpr := range keys {
+		perKey[fpr] = compareKeyCopies(fpr, copies[fpr], errors[fpr])
+	}
+	report := buildConsistencyReport(keys, perKey)
+	report.Started = started
+	report.Finished = time.Now()
+	return report
+}
+
+var (
+	currentConsistency     *ConsistencyReport
+	currentConsistencyLock sync.RWMutex
+	// consistencyRunning stops a slow check overlapping the next scan's.
+	consistencyRunning     bool
+	consistencyRunningLock sync.Mutex
+)
+
+func GetCurrentConsistency() *ConsistencyReport {
+	currentConsistencyLock.RLock()
+	defer currentConsistencyLock.RUnlock()
+	return currentConsistency
+}
+
+// runConsistencyCheck is the job run after each scan, if there are keys to
+// check.  If the previous scan's check is still going, this one is skipped.
+func runConsistencyCheck(persisted *PersistedHostInfo) {
+	keys := consistencyKeys()
+	if len(keys) == 0 {
+		return
+	}
+	consistencyRunningLock.Lock()
+	if consistencyRunning {
+		consistencyRunningLock.Unlock()
+		Log.Printf("Skipping consistency check, the previous one is still running")
+		return
+	}
+	consistencyRunning = true
+	consistencyRunningLock.Unlock()
+	defer func() {
+		consistencyRunningLock.Lock()
+		consistencyRunning = false
+		consistencyRunningLock.Unlock()
+	}()
+
+	Log.Printf("Starting consistency check of %d keys", len(keys))
+	report := CheckConsistency(persisted, keys)
+	diverging := 0
+	for _, server := range report.Servers {
+		if server.Divergence > 0 {
+			diverging++
+		}
+	}
+	Log.Printf("Consistency check done: %d of %d servers diverge", diverging, len(report.Servers))
+	storeConsistency(report)
+}
+
+// storeConsistency keeps the report unless we already have a later one.
+func storeConsistency(report *ConsistencyReport) {
+	currentConsistencyLock.Lock()
+	defer currentConsistencyLock.Unlock()
+	if currentConsistency != nil && currentConsistency.Started.After(report.Started) {
+		return
+	}
+	currentConsistency = report
+}
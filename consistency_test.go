@@ -0,0 +1,113 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func testSignaturePacket(sigType, seed byte) []byte {
+	body := []byte{4, sigType, 1, 8, 0, 0, 0, 0, seed, seed}
+	return append([]byte{0xC0 | packetTagSignature, byte(len(body))}, body...)
+}
+
+func TestConsistencyComparison(t *testing.T) {
+	key, fpr := testKeyPacket(0x11)
+	certified := append(append([]byte{}, key...), testSignaturePacket(0x13, 1)...)
+	extraSig := append(append([]byte{}, certified...), testSignaturePacket(0x10, 2)...)
+	revoked := append(append([]byte{}, certified...), testSignaturePacket(0x30, 3)...)
+
+	components, err := keyComponents(revoked)
+	if err != nil {
+		t.Fatalf("keyComponents failed: %s", err)
+	}
+	kinds := make(map[string]int)
+	for _, kind := range components {
+		kinds[kind]++
+	}
+	if kinds[ComponentUID] != 1 || kinds[ComponentSignature] != 1 || kinds[ComponentRevocation] != 1 {
+		t.Fatalf("Unexpected components: %v", components)
+	}
+
+	copies := make(map[string]map[string]string)
+	for hostname, data := range map[string][]byte{
+		"a.example.org":     revoked,
+		"b.example.org":     revoked,
+		"c.example.org":     revoked,
+		"stale.example.org": certified,
+		"odd.example.org":   extraSig,
+	} {
+		if copies[hostname], err = keyComponents(data); err != nil {
+			t.Fatalf("keyComponents(%s) failed: %s", hostname, err)
+		}
+	}
+	perKey := map[string]map[string]*ConsistencyKeyResult{
+		fpr: compareKeyCopies(fpr, copies, map[string]string{"down.example.org": "HTTP status 500"}),
+	}
+	report := buildConsistencyReport([]string{fpr}, perKey)
+
+	divergence := make(map[string]*ConsistencyServer)
+	for _, server := range report.Servers {
+		divergence[server.Hostname] = server
+	}
+	if d := divergence["a.example.org"]; d.Divergence != 0 || d.Highlight {
+		t.Fatalf("Agreeing server diverges: %+v", d)
+	}
+	if d := divergence["stale.example.org"]; d.Divergence != 1 || d.Keys[0].Missing[ComponentRevocation] != 1 || !d.Highlight {
+		t.Fatalf("Stale revocation not reported: %+v", d)
+	}
+	if d := divergence["odd.example.org"]; d.Keys[0].Missing[ComponentRevocation] != 1 || d.Keys[0].Extra[ComponentSignature] != 1 {
+		t.Fatalf("Extra signature not reported: %+v", d.Keys[0])
+	}
+	if d := divergence["down.example.org"]; d.Divergence != 3 || d.Keys[0].Error == "" {
+		t.Fatalf("Failed fetch not counted as missing everything: %+v", d)
+	}
+	if report.Servers[0].Hostname != "down.example.org" {
+		t.Fatalf("Worst server not first, got %s", report.Servers[0].Hostname)
+	}
+}
+
+func TestConsistencyRunsSerialised(t *testing.T) {
+	if Log == nil {
+		Log = log.New(ioutil.Discard, "", 0)
+	}
+	savedReport, savedKeys := currentConsistency, *flConsistencyKeys
+	defer func() { currentConsistency, *flConsistencyKeys = savedReport, savedKeys }()
+
+	now := time.Now()
+	later := &ConsistencyReport{Started: now}
+	earlier := &ConsistencyReport{Started: now.Add(-time.Hour)}
+	currentConsistency = nil
+	storeConsistency(later)
+	storeConsistency(earlier)
+	if GetCurrentConsistency() != later {
+		t.Fatal("A check which started earlier replaced a later one")
+	}
+
+	// With a check running, another must not start: given no mesh, it
+	// would crash if it did.
+	*flConsistencyKeys = "0123456789ABCDEF0123456789ABCDEF01234567"
+	consistencyRunning = true
+	defer func() { consistencyRunning = false }()
+	runConsistencyCheck(nil)
+	if GetCurrentConsistency() != later {
+		t.Fatal("Overlapping check stored a report")
+	}
+}
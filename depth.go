@@ -18,15 +18,22 @@ package sks_spider
 
 import (
 	"sort"
+	"strings"
 )
 
 func GenerateDepthSorted(hostmap HostMap) []string {
+	return GenerateDepthSortedBy(hostmap, func(name string) int { return hostmap[name].Distance })
+}
+
+// GenerateDepthSortedBy sorts the hosts by a distance other than the one
+// recorded in the hostmap, such as from another perspective.
+func GenerateDepthSortedBy(hostmap HostMap, distanceOf func(string) int) []string {
 	var by_depth = make(map[int][]string, 7)
 	var per_depth_len = len(hostmap)
 	var ordered_entries = make([]string, 0, len(hostmap))
 
-	for name, node := range hostmap {
-		depth := node.Distance
+	for name := range hostmap {
+		depth := distanceOf(name)
 		if _, ok := by_depth[depth]; !ok {
 			by_depth[depth] = make([]string, 0, per_depth_len)
 		}
@@ -57,3 +64,66 @@ func GenerateDepthSorted(hostmap HostMap) []string {
 
 	return ordered_entries
 }
+
+// AssignDistances sets the Distance and MutualDistance of every host by a
+// breadth-first search of the peering graph from the seeds, which map
+// canonical hostname to seed distance.
+func (p *PersistedHostInfo) AssignDistances(seeds map[string]int) {
+	lowered := make(map[string]int, len(seeds))
+	for name, d := range seeds {
+		if canon, ok := p.Graph.Canonical(name); ok {
+			name = canon
+		}
+		name = strings.ToLower(name)
+		if old, ok := lowered[name]; !ok || d < old {
+			lowered[name] = d
+		}
+	}
+	directed := seededBreadthFirst(lowered, -1, p.Graph.outboundList)
+	mutual := seededBreadthFirst(lowered, -1, p.Graph.MutualPeersOf)
+	for hostname, node := range p.HostMap {
+		name := strings.ToLower(hostname)
+		node.Distance = -1
+		node.MutualDistance = -1
+		if d, ok := directed[name]; ok {
+			node.Distance = d
+		}
+		if d, ok := mutual[name]; ok {
+			node.MutualDistance = d
+		}
+	}
+}
+
+// DistancesFrom is the hop-count to every host in the hostmap from one host,
+// following gossip links; -1 for those unreachable.
+func (p *PersistedHostInfo) DistancesFrom(host string) (map[string]int, bool) {
+	canon, ok := p.Graph.Canonical(host)
+	if !ok {
+		return nil, false
+	}
+	hops := breadthFirst([]string{strings.ToLower(canon)}, -1, p.Graph.outboundList)
+	distances := make(map[string]int, len(p.HostMap))
+	for hostname := range p.HostMap {
+		if d, ok := hops[strings.ToLower(hostname)]; ok {
+			distances[hostname] = d
+		} else {
+			distances[hostname] = -1
+		}
+	}
+	return distances, true
+}
+
+// seedsFromHostmap recovers the seeds of a scan saved as JSON: the hosts which
+// were at distance 0, or failing that our start host.
+func seedsFromHostmap(hostmap HostMap) map[string]int {
+	seeds := make(map[string]int)
+	for hostname, node := range hostmap {
+		if node.Distance == 0 {
+			seeds[hostname] = 0
+		}
+	}
+	if len(seeds) == 0 {
+		seeds[*flSpiderStartHost] = 0
+	}
+	return seeds
+}
@@ -54,3 +54,85 @@ func TestDepthSnapshot(t *testing.T) {
 	}
 	t.Logf("Depth OK; %d entries, max distance %d", len(depthSorted), distance)
 }
+
+func TestDistancesRecomputed(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	seeds := seedsFromHostmap(persisted.HostMap)
+	if len(seeds) != 1 {
+		t.Fatalf("Expected one seed in snapshot, got %v", seeds)
+	}
+	persisted.AssignDistances(seeds)
+
+	for hostname, node := range persisted.HostMap {
+		name := strings.ToLower(hostname)
+		if _, isSeed := seeds[hostname]; isSeed {
+			if node.Distance != 0 || node.MutualDistance != 0 {
+				t.Fatalf("Seed %q at distance %d/%d", hostname, node.Distance, node.MutualDistance)
+			}
+			continue
+		}
+		if node.Distance < 0 {
+			continue
+		}
+		if node.MutualDistance >= 0 && node.MutualDistance < node.Distance {
+			t.Fatalf("Host %q mutual distance %d shorter than directed %d", hostname, node.MutualDistance, node.Distance)
+		}
+		// Breadth-first: some host pointing at us is one hop nearer the seed,
+		// and nothing pointing at us is nearer than that.
+		foundPredecessor := false
+		for _, from := range persisted.Graph.inboundList(name) {
+			fromNode, ok := persisted.HostMap[from]
+			if !ok || fromNode.Distance < 0 {
+				continue
+			}
+			if fromNode.Distance < node.Distance-1 {
+				t.Fatalf("Host %q at distance %d but linked from %q at %d", hostname, node.Distance, from, fromNode.Distance)
+			}
+			if fromNode.Distance == node.Distance-1 {
+				foundPredecessor = true
+			}
+		}
+		if !foundPredecessor {
+			t.Fatalf("Host %q at distance %d has no predecessor", hostname, node.Distance)
+		}
+	}
+
+	seededFurther := seededBreadthFirst(map[string]int{"a": 0, "d": 5}, -1, func(name string) []string {
+		return map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d"}, "d": {"e"}}[name]
+	})
+	if seededFurther["d"] != 3 || seededFurther["e"] != 4 {
+		t.Fatalf("Seed at distance 5 not overridden by nearer path: %v", seededFurther)
+	}
+}
+
+func TestDistancesFromPerspective(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	if _, ok := persisted.DistancesFrom("no-such-host.example.org"); ok {
+		t.Fatal("Got distances from an unknown host")
+	}
+	// A direct peer of the seed which also lists itself among its peers.
+	other := "ranger.ky9k.org"
+	if node, ok := persisted.HostMap[other]; !ok || node.AnalyzeError != "" {
+		t.Fatalf("Fixture lacks a usable %q", other)
+	}
+	distances, ok := persisted.DistancesFrom(other)
+	if !ok {
+		t.Fatalf("No distances from %q", other)
+	}
+	if distances[other] != 0 {
+		t.Fatalf("Perspective host %q at distance %d from itself", other, distances[other])
+	}
+	for _, peer := range persisted.Graph.outboundList(strings.ToLower(other)) {
+		want := 1
+		if peer == strings.ToLower(other) {
+			want = 0
+		}
+		if d, ok := distances[peer]; ok && d != want {
+			t.Fatalf("Peer %q of %q at distance %d, expected %d", peer, other, d, want)
+		}
+	}
+	sorted := GenerateDepthSortedBy(persisted.HostMap, func(name string) int { return distances[name] })
+	if len(sorted) != len(persisted.HostMap) || sorted[0] != other {
+		t.Fatalf("Depth sort from %q starts with %q, %d of %d hosts", other, sorted[0], len(sorted), len(persisted.HostMap))
+	}
+}
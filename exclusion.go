@@ -0,0 +1,272 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Hosts we never query.  Some operators ask to be left alone; some hosts are
+// slow slow slow to fail; and people put dumb things in their membership
+// files.  The policy file has one rule per line:
+//
+//	pattern [expires=YYYY-MM-DD] [reason ...]
+//
+// where the pattern is a hostname, a glob on hostnames, or a CIDR block which
+// is checked against the addresses a hostname resolves to.  '#' starts a
+// comment.  The file is re-read on SIGHUP, or when its mtime changes.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ExclusionHost = "host"
+	ExclusionGlob = "glob"
+	ExclusionCIDR = "cidr"
+
+	ExclusionBuiltin = "built-in"
+)
+
+type ExclusionRule struct {
+	Pattern string
+	Kind    string
+	Reason  string
+	Expires time.Time // zero for never
+	Source  string
+	Line    int
+	block   *net.IPNet
+}
+
+func (er *ExclusionRule) Expired(now time.Time) bool {
+	return !er.Expires.IsZero() && !now.Before(er.Expires)
+}
+
+func (er *ExclusionRule) String() string {
+	s := er.Pattern
+	if er.Reason != "" {
+		s += " (" + er.Reason + ")"
+	}
+	return s
+}
+
+func (er *ExclusionRule) matchHost(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	switch er.Kind {
+	case ExclusionHost:
+		return hostname == er.Pattern
+	case ExclusionGlob:
+		matched, _ := path.Match(er.Pattern, hostname)
+		return matched
+	}
+	return false
+}
+
+var builtinExclusions = []*ExclusionRule{
+	{Pattern: "localhost", Kind: ExclusionHost, Reason: "loopback"},
+	{Pattern: "127.0.0.1", Kind: ExclusionHost, Reason: "loopback"},
+	{Pattern: "::1", Kind: ExclusionHost, Reason: "loopback"},
+	{Pattern: "*pool.*", Kind: ExclusionGlob, Reason: "pool hostnames are aggregates of other servers"},
+	{Pattern: "*.local", Kind: ExclusionGlob, Reason: "link-local mDNS name"},
+}
+
+type ExclusionPolicy struct {
+	Filename string
+	Rules    []*ExclusionRule
+	Warnings []string
+	Loaded   time.Time
+	mtime    time.Time
+}
+
+func newExclusionRule(pattern string) (*ExclusionRule, error) {
+	if strings.Contains(pattern, "/") {
+		_, block, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &ExclusionRule{Pattern: block.String(), Kind: ExclusionCIDR, block: block}, nil
+	}
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad glob %q: %s", pattern, err)
+		}
+		return &ExclusionRule{Pattern: pattern, Kind: ExclusionGlob}, nil
+	}
+	return &ExclusionRule{Pattern: pattern, Kind: ExclusionHost}, nil
+}
+
+// ParseExclusionPolicy reads a policy file; bad lines become warnings, after
+// the built-in rules.
+func ParseExclusionPolicy(in io.Reader, source string) (*ExclusionPolicy, error) {
+	policy := &ExclusionPolicy{Filename: source}
+	for _, rule := range builtinExclusions {
+		r, _ := newExclusionRule(rule.Pattern)
+		r.Reason = rule.Reason
+		r.Source = ExclusionBuiltin
+		policy.Rules = append(policy.Rules, r)
+	}
+
+	scanner := bufio.NewScanner(in)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := newExclusionRule(fields[0])
+		if err != nil {
+			policy.Warnings = append(policy.Warnings, fmt.Sprintf("line %d: %s", number, err))
+			continue
+		}
+		rule.Source = source
+		rule.Line = number
+		fields = fields[1:]
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "expires=") {
+			rule.Expires, err = time.Parse("2006-01-02", strings.TrimPrefix(fields[0], "expires="))
+			if err != nil {
+				policy.Warnings = append(policy.Warnings, fmt.Sprintf("line %d: bad expiry date: %s", number, err))
+				continue
+			}
+			fields = fields[1:]
+		}
+		rule.Reason = strings.Join(fields, " ")
+		policy.Rules = append(policy.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	policy.Loaded = time.Now()
+	return policy, nil
+}
+
+func LoadExclusionPolicy(filename string) (*ExclusionPolicy, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParseExclusionPolicy(fh, filename)
+	if err != nil {
+		return nil, err
+	}
+	policy.mtime = fi.ModTime()
+	return policy, nil
+}
+
+// ExcludeHost returns the live rule excluding the hostname, if any.
+func (policy *ExclusionPolicy) ExcludeHost(hostname string) *ExclusionRule {
+	now := time.Now()
+	for _, rule := range policy.Rules {
+		if rule.Kind != ExclusionCIDR && !rule.Expired(now) && rule.matchHost(hostname) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ExcludeIP returns the live CIDR rule containing the address, if any.
+func (policy *ExclusionPolicy) ExcludeIP(ipstr string) *ExclusionRule {
+	ip := net.ParseIP(ipstr)
+	if ip == nil {
+		return nil
+	}
+	now := time.Now()
+	for _, rule := range policy.Rules {
+		if rule.Kind == ExclusionCIDR && !rule.Expired(now) && rule.block.Contains(ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+var (
+	currentExclusions     *ExclusionPolicy
+	currentExclusionsLock sync.RWMutex
+)
+
+// GetExclusionPolicy never returns nil; without a policy file, we still have
+// the built-in rules.
+func GetExclusionPolicy() *ExclusionPolicy {
+	currentExclusionsLock.RLock()
+	policy := currentExclusions
+	currentExclusionsLock.RUnlock()
+	if policy == nil {
+		policy, _ = ParseExclusionPolicy(strings.NewReader(""), ExclusionBuiltin)
+	}
+	return policy
+}
+
+func setExclusionPolicy(policy *ExclusionPolicy) {
+	currentExclusionsLock.Lock()
+	defer currentExclusionsLock.Unlock()
+	currentExclusions = policy
+}
+
+// ReloadExclusionPolicy re-reads the policy file; on failure, the rules
+// already in force stay that way.
+func ReloadExclusionPolicy() error {
+	if *flExclusionPolicy == "" {
+		return nil
+	}
+	policy, err := LoadExclusionPolicy(*flExclusionPolicy)
+	if err != nil {
+		Log.Printf("Failed to load exclusion policy \"%s\", keeping previous rules: %s", *flExclusionPolicy, err)
+		return err
+	}
+	for _, w := range policy.Warnings {
+		Log.Printf("%s: %s", *flExclusionPolicy, w)
+	}
+	Log.Printf("Loaded %d exclusion rules from \"%s\"", len(policy.Rules), *flExclusionPolicy)
+	setExclusionPolicy(policy)
+	return nil
+}
+
+// watchExclusionPolicy reloads the policy on signal, or when the file
+// changes; it does not return.
+func watchExclusionPolicy(hup <-chan os.Signal) {
+	ticker := time.NewTicker(*flExclusionRecheck)
+	lastTried := GetExclusionPolicy().mtime
+	for {
+		select {
+		case <-hup:
+			Log.Printf("Exclusion policy reload requested")
+			ReloadExclusionPolicy()
+		case <-ticker.C:
+			fi, err := os.Stat(*flExclusionPolicy)
+			// don't keep retrying a broken file until it changes again
+			if err != nil || fi.ModTime().Equal(lastTried) {
+				continue
+			}
+			lastTried = fi.ModTime()
+			ReloadExclusionPolicy()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"strings"
+	"testing"
+)
+
+const testExclusionPolicy = `# operators who asked to be left alone
+keys.example.org   asked by operator, 2026-03-01
+*.slow.example.net expires=2000-01-01 was slow to fail
+*.mirror.example.com
+198.51.100.0/24    expires=2999-12-31 hosting provider abuse
+bad[glob
+203.0.113.0/33
+quiet.example.org expires=soon
+`
+
+func TestExclusionPolicy(t *testing.T) {
+	policy, err := ParseExclusionPolicy(strings.NewReader(testExclusionPolicy), "test")
+	if err != nil {
+		t.Fatalf("ParseExclusionPolicy failed: %s", err)
+	}
+	if len(policy.Warnings) != 3 {
+		t.Errorf("Expected 3 warnings, got %d: %v", len(policy.Warnings), policy.Warnings)
+	}
+
+	for hostname, expected := range map[string]string{
+		"keys.example.org":        "keys.example.org",
+		"KEYS.example.org.":       "keys.example.org",
+		"a.mirror.example.com":    "*.mirror.example.com",
+		"host.slow.example.net":   "",
+		"sks.pool.example.net":    "*pool.*",
+		"localhost":               "localhost",
+		"printer.local":           "*.local",
+		"other.example.org":       "",
+		"quiet.example.org":       "",
+		"keys.example.org.au":     "",
+		"198.51.100.1.nip.io.net": "",
+	} {
+		rule := policy.ExcludeHost(hostname)
+		switch {
+		case expected == "" && rule != nil:
+			t.Errorf("Host %q excluded by %s", hostname, rule)
+		case expected != "" && (rule == nil || rule.Pattern != expected):
+			t.Errorf("Host %q matched %v, expected %q", hostname, rule, expected)
+		}
+	}
+
+	if rule := policy.ExcludeIP("198.51.100.20"); rule == nil || rule.Reason != "hosting provider abuse" {
+		t.Errorf("IP not excluded by CIDR rule, got %v", rule)
+	}
+	if rule := policy.ExcludeIP("198.51.101.20"); rule != nil {
+		t.Errorf("IP wrongly excluded by %s", rule)
+	}
+}
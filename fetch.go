@@ -0,0 +1,121 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Support for fetching stats pages: we don't trust the far end to send us a
+// sane amount of data, of the type it claims, from where we asked.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+const maxFetchRedirects = 5
+
+type bodyKind int
+
+const (
+	bodyUnknown bodyKind = iota // no useful Content-Type; try JSON, then HTML
+	bodyJSON
+	bodyHTML
+)
+
+// FetchTimings are from the start of the request; zero if never reached.
+type FetchTimings struct {
+	Connect time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+func (ft *FetchTimings) String() string {
+	return fmt.Sprintf("connect %s, first byte %s, total %s",
+		ft.Connect.Round(time.Millisecond), ft.TTFB.Round(time.Millisecond), ft.Total.Round(time.Millisecond))
+}
+
+// withFetchTimings records into timings as the request progresses; the caller
+// fills in Total.  With redirects, Connect is for the last connection made.
+func withFetchTimings(ctx context.Context, start time.Time, timings *FetchTimings) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				timings.Connect = time.Since(start)
+			}
+		},
+		GotFirstResponseByte: func() {
+			timings.TTFB = time.Since(start)
+		},
+	})
+}
+
+// checkRedirectFor follows redirects on the same host, noting each in the
+// node, and refuses any to somewhere else.
+func (sn *SksNode) checkRedirectFor(req *http.Request, via []*http.Request) error {
+	if len(via) < maxFetchRedirects {
+		sn.Redirects = append(sn.Redirects, req.URL.String())
+	}
+	return checkSameHostRedirect(req, via)
+}
+
+// checkSameHostRedirect is the redirect policy for all our fetches.
+func checkSameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxFetchRedirects {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return fmt.Errorf("refusing off-host redirect to <%s>", req.URL)
+	}
+	return nil
+}
+
+func classifyContentType(header string) (bodyKind, error) {
+	if header == "" {
+		return bodyUnknown, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return bodyUnknown, fmt.Errorf("bad Content-Type %q: %s", header, err)
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return bodyJSON, nil
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return bodyHTML, nil
+	case mediaType == "text/plain":
+		return bodyUnknown, nil
+	}
+	return bodyUnknown, fmt.Errorf("unexpected Content-Type %q", mediaType)
+}
+
+// readBoundedBody reads the whole body, failing rather than reading more
+// than limit bytes.
+func readBoundedBody(body io.Reader, limit int64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return nil, fmt.Errorf("response body larger than limit of %d bytes", limit)
+	}
+	return buf, nil
+}
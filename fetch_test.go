@@ -0,0 +1,73 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFetchBodyHandling(t *testing.T) {
+	if _, err := readBoundedBody(strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatalf("Body at limit refused: %s", err)
+	}
+	if _, err := readBoundedBody(strings.NewReader("0123456789X"), 10); err == nil {
+		t.Fatal("Oversize body accepted")
+	}
+
+	for header, expected := range map[string]bodyKind{
+		"":                          bodyUnknown,
+		"text/plain; charset=UTF-8": bodyUnknown,
+		"text/html; charset=UTF-8":  bodyHTML,
+		"application/json":          bodyJSON,
+		"application/hkp+json":      bodyJSON,
+	} {
+		if kind, err := classifyContentType(header); err != nil || kind != expected {
+			t.Errorf("Content-Type %q gave %v, %v; expected %v", header, kind, err, expected)
+		}
+	}
+	for _, header := range []string{"image/png", "text/html; charset"} {
+		if _, err := classifyContentType(header); err == nil {
+			t.Errorf("Content-Type %q accepted", header)
+		}
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	request := func(uri string) *http.Request {
+		u, _ := url.Parse(uri)
+		return &http.Request{URL: u}
+	}
+	original := []*http.Request{request("http://keys.example.org:11371/pks/lookup?op=stats")}
+	node := &SksNode{Hostname: "keys.example.org"}
+
+	if err := node.checkRedirectFor(request("https://KEYS.example.org/pks/lookup?op=stats"), original); err != nil {
+		t.Fatalf("Same-host redirect refused: %s", err)
+	}
+	if err := node.checkRedirectFor(request("http://169.254.169.254/latest/meta-data/"), original); err == nil {
+		t.Fatal("Off-host redirect followed")
+	}
+	if len(node.Redirects) != 2 {
+		t.Fatalf("Redirects not recorded: %v", node.Redirects)
+	}
+	many := append(original, original[0], original[0], original[0], original[0])
+	if err := node.checkRedirectFor(request("http://keys.example.org/"), many); err == nil {
+		t.Fatal("Redirect loop not stopped")
+	}
+}
@@ -136,11 +136,18 @@ func GenerateGraph(names []string, sksnodes HostMap, aliases AliasMap) *HostGrap
 }
 
 func (hg *HostGraph) LabelMutualWithBase(name string) string {
-	baseCanon, ok := hg.aliases[*flSpiderStartHost]
+	return hg.LabelMutualWith(*flSpiderStartHost, name)
+}
+
+// LabelMutualWith describes whether name and base peer with each other, for
+// display in a table.
+func (hg *HostGraph) LabelMutualWith(base, name string) string {
+	baseCanon, ok := hg.Canonical(base)
 	if !ok {
-		panic("no known alias for start host")
+		// can't say, we don't know the perspective host
+		return "?"
 	}
-	canon, ok := hg.aliases[name]
+	canon, ok := hg.Canonical(name)
 	switch {
 	case !ok:
 		// can't be mutual, we don't even know the name
@@ -153,3 +160,106 @@ func (hg *HostGraph) LabelMutualWithBase(name string) string {
 		return "No"
 	}
 }
+
+// Canonical returns the canonical graph name for a hostname or alias.
+func (hg *HostGraph) Canonical(name string) (string, bool) {
+	canon, ok := hg.aliases[strings.ToLower(name)]
+	if !ok {
+		canon, ok = hg.aliases[name]
+	}
+	return canon, ok
+}
+
+func (hg *HostGraph) outboundList(name string) []string {
+	if set, ok := hg.outbound[name]; ok {
+		return set.AllData()
+	}
+	return nil
+}
+
+func (hg *HostGraph) inboundList(name string) []string {
+	if set, ok := hg.inbound[name]; ok {
+		return set.AllData()
+	}
+	return nil
+}
+
+// MutualPeersOf returns the peers which name lists and which list name back.
+func (hg *HostGraph) MutualPeersOf(name string) []string {
+	inbound, ok := hg.inbound[name]
+	if !ok {
+		return nil
+	}
+	mutual := make([]string, 0, inbound.Len())
+	for _, peer := range hg.outboundList(name) {
+		if inbound.Contains(peer) {
+			mutual = append(mutual, peer)
+		}
+	}
+	return mutual
+}
+
+func (hg *HostGraph) eitherWayPeersOf(name string) []string {
+	return append(hg.outboundList(name), hg.inboundList(name)...)
+}
+
+// breadthFirst walks a graph from the starting names, using next to find the
+// neighbours of each host, and returns the hop-count to every host reached.
+// A negative maxDepth means no limit.
+func breadthFirst(starts []string, maxDepth int, next func(string) []string) map[string]int {
+	seeded := make(map[string]int, len(starts))
+	for _, s := range starts {
+		seeded[s] = 0
+	}
+	return seededBreadthFirst(seeded, maxDepth, next)
+}
+
+// seededBreadthFirst is breadthFirst where the starting hosts need not all be
+// at distance 0; a seed is treated as being that many hops from "somewhere".
+func seededBreadthFirst(starts map[string]int, maxDepth int, next func(string) []string) map[string]int {
+	distances := make(map[string]int)
+	byLevel := make(map[int][]string)
+	lastSeedLevel := 0
+	for name, d := range starts {
+		byLevel[d] = append(byLevel[d], name)
+		if d > lastSeedLevel {
+			lastSeedLevel = d
+		}
+	}
+	for level := 0; level <= lastSeedLevel || len(byLevel[level]) > 0; level++ {
+		frontier := make([]string, 0, len(byLevel[level]))
+		for _, name := range byLevel[level] {
+			if _, seen := distances[name]; seen {
+				continue
+			}
+			distances[name] = level
+			frontier = append(frontier, name)
+		}
+		delete(byLevel, level)
+		if maxDepth >= 0 && level >= maxDepth {
+			continue
+		}
+		for _, name := range frontier {
+			for _, peer := range next(name) {
+				if _, seen := distances[peer]; !seen {
+					byLevel[level+1] = append(byLevel[level+1], peer)
+				}
+			}
+		}
+	}
+	return distances
+}
+
+// Neighbourhood returns the hosts within depth hops of name, following gossip
+// links in either direction (or only mutual links), with their hop-counts.
+func (hg *HostGraph) Neighbourhood(name string, depth int, mutualOnly bool) map[string]int {
+	canon, ok := hg.Canonical(name)
+	if !ok {
+		return map[string]int{}
+	}
+	next := hg.eitherWayPeersOf
+	if mutualOnly {
+		next = hg.MutualPeersOf
+	}
+	return breadthFirst([]string{canon}, depth, next)
+}
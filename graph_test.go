@@ -0,0 +1,137 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// smallTestPersisted is a <-> b -> c <-> d, with e peering only with d, and
+// www.a.example.org an alias of a.
+func smallTestPersisted() *PersistedHostInfo {
+	peers := map[string][]string{
+		"a.example.org": {"b.example.org"},
+		"b.example.org": {"www.a.example.org", "c.example.org"},
+		"c.example.org": {"d.example.org"},
+		"d.example.org": {"c.example.org", "e.example.org"},
+		"e.example.org": {"d.example.org"},
+	}
+	hostMap := make(HostMap, len(peers))
+	for name, list := range peers {
+		hostMap[name] = &SksNode{Hostname: name, GossipPeerList: list, Keycount: 5000000}
+	}
+	hostMap["a.example.org"].Aliases = []string{"www.a.example.org"}
+	aliasMap := GetAliasMapForHostmap(hostMap)
+	hostnames := GenerateHostlistSorted(hostMap)
+	return &PersistedHostInfo{
+		HostMap:  hostMap,
+		AliasMap: aliasMap,
+		Sorted:   hostnames,
+		Graph:    GenerateGraph(hostnames, hostMap, aliasMap),
+	}
+}
+
+func TestBreadthFirstDepthLimit(t *testing.T) {
+	chain := func(name string) []string {
+		return map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d"}}[name]
+	}
+	for _, tc := range []struct {
+		depth int
+		want  int
+	}{{0, 1}, {1, 2}, {2, 3}, {-1, 4}, {10, 4}} {
+		got := breadthFirst([]string{"a"}, tc.depth, chain)
+		if len(got) != tc.want {
+			t.Errorf("depth %d: reached %d hosts, expected %d: %v", tc.depth, len(got), tc.want, got)
+		}
+		for name, d := range got {
+			if tc.depth >= 0 && d > tc.depth {
+				t.Errorf("depth %d: %q at distance %d", tc.depth, name, d)
+			}
+		}
+	}
+}
+
+func TestNeighbourhood(t *testing.T) {
+	persisted := smallTestPersisted()
+	for _, tc := range []struct {
+		name   string
+		host   string
+		depth  int
+		mutual bool
+		want   map[string]int
+	}{
+		{"depth 1", "a.example.org", 1, false, map[string]int{"a.example.org": 0, "b.example.org": 1}},
+		{"by alias", "WWW.A.Example.ORG", 1, false, map[string]int{"a.example.org": 0, "b.example.org": 1}},
+		{"depth 2", "a.example.org", 2, false, map[string]int{"a.example.org": 0, "b.example.org": 1, "c.example.org": 2}},
+		{"against the links", "c.example.org", 1, false, map[string]int{"c.example.org": 0, "b.example.org": 1, "d.example.org": 1}},
+		{"mutual only", "a.example.org", 3, true, map[string]int{"a.example.org": 0, "b.example.org": 1}},
+		{"unlimited", "e.example.org", -1, false, map[string]int{
+			"e.example.org": 0, "d.example.org": 1, "c.example.org": 2, "b.example.org": 3, "a.example.org": 4}},
+		{"unknown host", "nowhere.example.org", 2, false, map[string]int{}},
+	} {
+		got := persisted.Graph.Neighbourhood(tc.host, tc.depth, tc.mutual)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, expected %v", tc.name, got, tc.want)
+			continue
+		}
+		for name, d := range tc.want {
+			if got[name] != d {
+				t.Errorf("%s: %q at %d, expected %d", tc.name, name, got[name], d)
+			}
+		}
+	}
+}
+
+func TestNewGraphSelection(t *testing.T) {
+	persisted := smallTestPersisted()
+	selection := func(query string) (*graphSelection, error) {
+		req := httptest.NewRequest("GET", "/sks-peers/graph-dot?"+query, nil)
+		if err := req.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		return newGraphSelection(req, persisted)
+	}
+
+	gs, err := selection("host=www.a.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"a.example.org": true, "B.example.org": true, "c.example.org": false} {
+		if gs.Has(name) != want {
+			t.Errorf("host=alias depth 1: Has(%q) = %v", name, !want)
+		}
+	}
+
+	gs, err = selection("host=c.example.org&depth=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gs.Has("c.example.org") || gs.Has("d.example.org") {
+		t.Error("depth=0 should select only the host itself")
+	}
+
+	if gs, err = selection(""); err != nil || !gs.Has("anything.example.org") {
+		t.Errorf("no selection should include everything: %v", err)
+	}
+
+	for _, bad := range []string{"host=nowhere.example.org", "host=a.example.org&depth=-1", "host=a.example.org&depth=two"} {
+		if _, err := selection(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// SKS stats pages carry "Daily Histogram" and "Hourly Histogram" tables of
+// new and updated keys per period; Hockeypuck gives the same as "daily" and
+// "hourly" lists in its JSON.  Each server's histogram is its own view of how
+// many updates reached it, so the median across the mesh is a fair update
+// rate, and a server which got nothing when the mesh got plenty stands out.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HistogramEntry struct {
+	Time    time.Time
+	New     int
+	Updated int
+}
+
+func (h *HistogramEntry) Total() int { return h.New + h.Updated }
+
+var histogramTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15",
+	"2006-01-02",
+}
+
+func parseHistogramTime(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range histogramTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised histogram time %q", text)
+}
+
+func parseHistogramCount(text string) (int, error) {
+	return strconv.Atoi(strings.Replace(strings.TrimSpace(text), ",", "", -1))
+}
+
+func sortHistogram(entries []*HistogramEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+}
+
+// parseHistogramRows takes the cell text of each row of an SKS histogram
+// table: time, new keys, updated keys.  Header rows have no cells; anything
+// else unparseable is skipped rather than losing the whole table.
+func parseHistogramRows(rows [][]string) []*HistogramEntry {
+	entries := make([]*HistogramEntry, 0, len(rows))
+	for _, cells := range rows {
+		if len(cells) < 3 {
+			continue
+		}
+		when, err := parseHistogramTime(cells[0])
+		if err != nil {
+			continue
+		}
+		newKeys, err1 := parseHistogramCount(cells[1])
+		updated, err2 := parseHistogramCount(cells[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		entries = append(entries, &HistogramEntry{Time: when, New: newKeys, Updated: updated})
+	}
+	sortHistogram(entries)
+	return entries
+}
+
+// parseHistogramJson takes a Hockeypuck "daily" or "hourly" list.
+func parseHistogramJson(value interface{}) []*HistogramEntry {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	entries := make([]*HistogramEntry, 0, len(list))
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, ok := fields["time"].(string)
+		if !ok {
+			continue
+		}
+		when, err := parseHistogramTime(text)
+		if err != nil {
+			continue
+		}
+		inserted, _ := fields["inserted"].(float64)
+		updated, _ := fields["updated"].(float64)
+		entries = append(entries, &HistogramEntry{Time: when, New: int(inserted), Updated: int(updated)})
+	}
+	sortHistogram(entries)
+	return entries
+}
+
+func (sn *SksNode) histogramFromTable(search string) ([]*HistogramEntry, error) {
+	table, err := sn.tableFollowing(search)
+	if err != nil {
+		return nil, err
+	}
+	nodelist, err := (*table).Search(".//tr")
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]string, 0, len(nodelist))
+	for i := range nodelist {
+		columns, err := nodelist[i].Search(".//td")
+		if err != nil {
+			continue
+		}
+		cells := make([]string, len(columns))
+		for j := range columns {
+			cells[j] = columns[j].Content()
+		}
+		rows = append(rows, cells)
+	}
+	return parseHistogramRows(rows), nil
+}
+
+type MeshRateBucket struct {
+	Time          time.Time `json:"time"`
+	Servers       int       `json:"servers"`
+	MedianNew     int       `json:"median_new"`
+	MedianUpdated int       `json:"median_updated"`
+}
+
+type SilentServer struct {
+	Hostname    string    `json:"hostname"`
+	Day         time.Time `json:"day"`
+	MeshUpdates int       `json:"mesh_updates"`
+}
+
+type UpdateRateReport struct {
+	Daily  []*MeshRateBucket `json:"daily"`
+	Hourly []*MeshRateBucket `json:"hourly"`
+	Silent []*SilentServer   `json:"silent"`
+}
+
+func meshRate(hostMap HostMap, histogramOf func(*SksNode) []*HistogramEntry) []*MeshRateBucket {
+	newCounts := make(map[time.Time][]int)
+	updatedCounts := make(map[time.Time][]int)
+	for _, node := range hostMap {
+		if !NodeHealthy(node) {
+			continue
+		}
+		for _, entry := range histogramOf(node) {
+			newCounts[entry.Time] = append(newCounts[entry.Time], entry.New)
+			updatedCounts[entry.Time] = append(updatedCounts[entry.Time], entry.Updated)
+		}
+	}
+	buckets := make([]*MeshRateBucket, 0, len(newCounts))
+	for when, counts := range newCounts {
+		buckets = append(buckets, &MeshRateBucket{
+			Time:          when,
+			Servers:       len(counts),
+			MedianNew:     medianInt(counts),
+			MedianUpdated: medianInt(updatedCounts[when]),
+		})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Time.Before(buckets[j].Time) })
+	return buckets
+}
+
+// latestCompleteDay is the last daily entry, unless that is today's, still
+// being filled in, in which case the one before.
+func latestCompleteDay(entries []*HistogramEntry, now time.Time) *HistogramEntry {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Time.Add(24 * time.Hour).After(now) {
+			continue
+		}
+		return entries[i]
+	}
+	return nil
+}
+
+// GenerateUpdateRate aggregates the servers' histograms and marks NoUpdates
+// on those which got nothing in their latest complete day while the mesh
+// median for that day was more than nothing.
+func GenerateUpdateRate(hostMap HostMap, now time.Time) *UpdateRateReport {
+	report := &UpdateRateReport{
+		Daily:  meshRate(hostMap, func(n *SksNode) []*HistogramEntry { return n.DailyHistogram }),
+		Hourly: meshRate(hostMap, func(n *SksNode) []*HistogramEntry { return n.HourlyHistogram }),
+		Silent: []*SilentServer{},
+	}
+	daily := make(map[time.Time]*MeshRateBucket, len(report.Daily))
+	for _, bucket := range report.Daily {
+		daily[bucket.Time] = bucket
+	}
+	for _, hostname := range GenerateHostlistSorted(hostMap) {
+		node := hostMap[hostname]
+		node.NoUpdates = false
+		if !NodeHealthy(node) {
+			continue
+		}
+		day := latestCompleteDay(node.DailyHistogram, now)
+		if day == nil || day.Total() > 0 {
+			continue
+		}
+		mesh, ok := daily[day.Time]
+		if !ok || mesh.Servers < 2 || mesh.MedianNew+mesh.MedianUpdated == 0 {
+			continue
+		}
+		node.NoUpdates = true
+		report.Silent = append(report.Silent, &SilentServer{
+			Hostname:    hostname,
+			Day:         day.Time,
+			MeshUpdates: mesh.MedianNew + mesh.MedianUpdated,
+		})
+	}
+	return report
+}
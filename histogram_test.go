@@ -0,0 +1,133 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseHistogramRows(t *testing.T) {
+	rows := [][]string{
+		{}, // header, all <th>
+		{"2026-10-18 ", "1,204", "3310"},
+		{"2026-10-17", "998", "2876"},
+		{"garbage", "1", "2"},
+		{"2026-10-16", "n/a", "2"},
+	}
+	entries := parseHistogramRows(rows)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Time.Day() != 17 || entries[1].Time.Day() != 18 {
+		t.Errorf("entries not sorted oldest first: %v, %v", entries[0].Time, entries[1].Time)
+	}
+	if entries[1].New != 1204 || entries[1].Updated != 3310 || entries[1].Total() != 4514 {
+		t.Errorf("bad counts for 2026-10-18: %+v", entries[1])
+	}
+
+	hourly := parseHistogramRows([][]string{{"2026-10-18 13", "40", "120"}})
+	if len(hourly) != 1 || hourly[0].Time.Hour() != 13 {
+		t.Errorf("hourly row not parsed: %+v", hourly)
+	}
+}
+
+func TestParseHistogramJson(t *testing.T) {
+	var page map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+	  "numkeys": 5000000,
+	  "daily": [
+	    {"time": "2026-10-18T00:00:00Z", "inserted": 12, "updated": 340},
+	    {"time": "2026-10-17T00:00:00Z", "inserted": 9, "updated": 301},
+	    {"time": 42, "inserted": 1, "updated": 1}
+	  ],
+	  "hourly": "nonsense"
+	}`), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily := parseHistogramJson(page["daily"])
+	if len(daily) != 2 || daily[0].Updated != 301 || daily[1].New != 12 {
+		t.Errorf("bad daily histogram: %+v", daily)
+	}
+	if hourly := parseHistogramJson(page["hourly"]); hourly != nil {
+		t.Errorf("expected nothing from a bad hourly list, got %+v", hourly)
+	}
+}
+
+func TestGenerateUpdateRate(t *testing.T) {
+	day := func(d, n, u int) *HistogramEntry {
+		return &HistogramEntry{Time: time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC), New: n, Updated: u}
+	}
+	hostMap := HostMap{
+		"a.example.org":      &SksNode{Keycount: 5000000, DailyHistogram: []*HistogramEntry{day(17, 10, 300), day(18, 5, 100)}},
+		"b.example.org":      &SksNode{Keycount: 5000000, DailyHistogram: []*HistogramEntry{day(17, 12, 320), day(18, 6, 110)}},
+		"c.example.org":      &SksNode{Keycount: 5000000, DailyHistogram: []*HistogramEntry{day(17, 8, 280)}},
+		"quiet.example.org":  &SksNode{Keycount: 4990000, DailyHistogram: []*HistogramEntry{day(17, 0, 0), day(18, 0, 0)}},
+		"nohist.example.org": &SksNode{Keycount: 5000000},
+	}
+	// Midday on the 18th: the 18th is still being filled in, so the 17th is
+	// the latest complete day.
+	report := GenerateUpdateRate(hostMap, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+
+	if len(report.Daily) != 2 {
+		t.Fatalf("expected 2 daily buckets, got %d", len(report.Daily))
+	}
+	if b := report.Daily[0]; b.Servers != 4 || b.MedianNew != 9 || b.MedianUpdated != 290 {
+		t.Errorf("bad bucket for the 17th: %+v", b)
+	}
+	if len(report.Silent) != 1 || report.Silent[0].Hostname != "quiet.example.org" {
+		t.Errorf("expected only quiet.example.org silent, got %+v", report.Silent)
+	}
+	if !hostMap["quiet.example.org"].NoUpdates || hostMap["a.example.org"].NoUpdates || hostMap["nohist.example.org"].NoUpdates {
+		t.Error("NoUpdates not marked as expected")
+	}
+}
+
+func TestUpdateRatePageHost(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	persisted.UpdateRate = GenerateUpdateRate(persisted.HostMap, time.Now())
+	defer withCurrentPersisted(persisted)()
+
+	for _, tc := range []struct {
+		host     string
+		status   int
+		hostname string
+	}{
+		{"sks.spodhuis.org", http.StatusOK, "sks.spodhuis.org"},
+		{"SKS.Spodhuis.ORG", http.StatusOK, "sks.spodhuis.org"},
+		{"pgp.mit.edu", http.StatusNotFound, ""},
+		{"nowhere.example.org", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		apiUpdateRatePage(rec, httptest.NewRequest("GET", "/sks-peers/update-rate?host="+tc.host, nil))
+		if rec.Code != tc.status {
+			t.Errorf("host=%s: status %d, expected %d", tc.host, rec.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var response struct{ Hostname string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Hostname != tc.hostname {
+			t.Errorf("host=%s: got hostname %q, expected %q (%v)", tc.host, response.Hostname, tc.hostname, err)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package sks_spider
 
 import (
+	"expvar"
 	"sort"
 	"strings"
 )
@@ -106,7 +107,6 @@ func GeneratePersistedInformation(spider *Spider) *PersistedHostInfo {
 		}
 		HostSort(hostMap[hostname].GossipPeerList)
 		HostSort(hostMap[hostname].MailsyncPeers)
-		hostMap[hostname].Distance = spider.distances[hostname]
 		// To let JSON Marshal/Unmarshal work:
 		if hostMap[hostname].analyzeError != nil {
 			hostMap[hostname].AnalyzeError = hostMap[hostname].analyzeError.Error()
@@ -114,6 +114,13 @@ func GeneratePersistedInformation(spider *Spider) *PersistedHostInfo {
 		}
 	}
 
+	ProbeHostIPs(hostMap)
+	CheckAdvertisedPorts(hostMap, aliasMap)
+	if *flReconProbe {
+		ProbeReconPorts(hostMap, aliasMap)
+	}
+	ProbeKeyLookups(hostMap)
+
 	countryMap := make(IPCountryMap, len(spider.countriesForIPs))
 	for ip, country := range spider.countriesForIPs {
 		if country != "" {
@@ -122,14 +129,30 @@ func GeneratePersistedInformation(spider *Spider) *PersistedHostInfo {
 	}
 
 	// TODO: spawn go-routines, wait, to do Geo resolution
-	return &PersistedHostInfo{
+	persisted := &PersistedHostInfo{
 		HostMap:      hostMap,
 		AliasMap:     aliasMap,
 		IPCountryMap: countryMap,
 		Sorted:       hostnames,
-		DepthSorted:  GenerateDepthSorted(hostMap),
 		Graph:        GenerateGraph(hostnames, hostMap, aliasMap),
+		Seeds:        spider.SeedReport(),
+		Skipped:      spider.SkippedReport(),
+	}
+
+	// The distances the spider noted were in order of discovery; now that we
+	// have the whole graph, work them out properly.
+	seeds := make(map[string]int, len(persisted.Seeds))
+	for _, seed := range persisted.Seeds {
+		if !seed.Usable {
+			continue
+		}
+		if old, ok := seeds[seed.Canonical]; !ok || seed.Distance < old {
+			seeds[seed.Canonical] = seed.Distance
+		}
 	}
+	persisted.AssignDistances(seeds)
+	persisted.DepthSorted = GenerateDepthSorted(hostMap)
+	return persisted
 }
 
 func GetFreshCountryForHostmap(hostMap HostMap) IPCountryMap {
@@ -175,4 +198,43 @@ func (p *PersistedHostInfo) UpdateStatsCounters(spider *Spider) {
 	statsServersBadDNS.Set(int64(len(spider.badDNS)))
 	statsServersTotal.Set(int64(len(p.HostMap)))
 	statsServersHostnamesSeen.Set(int64(len(spider.considering)))
+
+	statsKeycountReference.Set(int64(p.KeycountReference))
+	statsServersKeysBehind.Init()
+	var countLagging, countStalled int64
+	for hostname, node := range p.HostMap {
+		if !NodeHealthy(node) {
+			continue
+		}
+		behind := new(expvar.Int)
+		behind.Set(int64(node.KeysBehind))
+		statsServersKeysBehind.Set(hostname, behind)
+		if node.KeysBehind > *flKeysLagWarn {
+			countLagging++
+		}
+		if node.SyncStalled {
+			countStalled++
+		}
+	}
+	statsServersLagging.Set(countLagging)
+	statsServersStalled.Set(countStalled)
+
+	statsAnomalies.Init()
+	for _, event := range p.Anomalies {
+		statsAnomalies.Add(string(event.Severity), 1)
+	}
+}
+
+// CountryForNode returns the country of the first of the node's IPs for which
+// we have a location, or "" if none is known.
+func (countryMap IPCountryMap) CountryForNode(node *SksNode) string {
+	if node == nil {
+		return ""
+	}
+	for _, ip := range node.IpList {
+		if country, ok := countryMap[ip]; ok && country != "" {
+			return country
+		}
+	}
+	return ""
 }
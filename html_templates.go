@@ -65,16 +65,21 @@ func prepareTemplates() {
 {{.Warning}}
 {{.Scanning_active}}
   <div class="explain">
-   Entries at depth 1 are direct peers of <span class="hostname">{{.StartHost}}</span>.
+   Entries at depth 1 are direct peers of <span class="hostname">{{.Perspective}}</span>.
    Others are seen by spidering the peers.
+{{if .Seeds}}
+   Spidering started from: {{range $i, $s := .Seeds}}{{if $i}}, {{end}}<span class="hostname">{{$s}}</span>{{end}}.
+{{end}}{{if .Unusable_seeds}}
+   Unusable seeds: {{range $i, $s := .Unusable_seeds}}{{if $i}}, {{end}}<span class="hostname">{{$s}}</span>{{end}}.
+{{end}}
   </div>
   <table class="sks peertable">
-   <thead><tr><th>Host</th><th>Info</th><th>IP</th><th>Geo</th><th>Mutual</th><th>Version</th><th>Keys</th><th>Distance</th><th>WebServer</th><th>Proxy/via</th></tr></thead>
+   <thead><tr><th>Host</th><th>Info</th><th>IP</th><th>Geo</th><th>Mutual</th><th>Version</th><th>Keys</th><th>Behind</th><th>Distance</th><th>WebServer</th><th>Proxy/via</th></tr></thead>
    <tbody>
 `
 
 	kPAGE_TEMPLATE_FOOT := `
-   <caption>SKS has {{.Peer_count}} peers of {{.Mesh_count}} visible</caption>
+   <caption>{{.Perspective}} has {{.Peer_count}} peers of {{.Mesh_count}} visible</caption>
   </table>
   <div class="lastupdate">Last scan completed at: {{.LastScanTime}}</div>
  </body>
@@ -85,11 +90,12 @@ func prepareTemplates() {
    <tr class="peer host {{.Rowclass}}">
     <td class="hostname"{{.Rowspan}}><a href="{{.Sks_info}}">{{.Hostname}}</a>{{.Host_aliases_text}}</td>
     <td class="morelink"{{.Rowspan}}><a href="{{.Info_page}}">&dagger;</a></td>
-    <td class="ipaddr">{{.Ip}}</td>
+    <td class="ipaddr {{.Ip_state}}" title="{{.Ip_probe}}">{{.Ip}}</td>
     <td class="location">{{.Geo}}</td>
     <td class="mutual"{{.Rowspan}}>{{.Mutual}}</td>
     <td class="version"{{.Rowspan}}>{{.Version}}</td>
     <td class="keys"{{.Rowspan}}>{{.Keycount}}</td>
+    <td class="keys_behind {{.Sync_state}}"{{.Rowspan}} title="{{.Sync_note}}">{{.KeysBehind}}</td>
     <td class="peer_distance"{{.Rowspan}}>{{.Distance}}</td>
     <td class="web_server"{{.Rowspan}}>{{.Web_server}}</td>
     <td class="via_proxy"{{.Rowspan}}>{{.Via_info}}</td>
@@ -100,7 +106,7 @@ func prepareTemplates() {
    <tr class="peer host failure {{.Rowclass}}">
     <td class="hostname">{{.Hostname}}</td>
     <td class="morelink"><a href="{{.Info_page}}">&dagger;</a></td>
-    <td class="exception" colspan="5">Error: {{.Error}}</td>
+    <td class="exception" colspan="6">Error: {{.Error}}</td>
     <td class="peer_distance">{{.Distance}}</td>
 	<td colspan="2"></td>
    </tr>
@@ -108,7 +114,7 @@ func prepareTemplates() {
 
 	kPAGE_TEMPLATE_HOSTMORE := `
    <tr class="peer more">
-    <td class="ipaddr">{{.Ip}}</td><td class="location">{{.Geo}}</td>
+    <td class="ipaddr {{.Ip_state}}" title="{{.Ip_probe}}">{{.Ip}}</td><td class="location">{{.Geo}}</td>
    </tr>
 `
 
@@ -125,11 +131,23 @@ func prepareTemplates() {
   <table class="peer_info">
    <tr><td>Name</td><td><a href="{{.Peer_statsurl}}">{{.Peername}}</a></td></tr>
    <tr><td>IPs</td><td>{{.Ips}}</td></tr>
-   <tr><td>Software</td><td>{{.Software}}</td></tr>
+   <tr><td>Stats port</td><td>{{.Hkp_port}} (advertised: {{.Advertised_ports}})</td></tr>
+   <tr><td>Recon probe</td><td>{{.Recon_probe}}</td></tr>
+   <tr><td>Recon settings</td><td>{{.Recon_settings}}</td></tr>
+   <tr><td>Key lookups</td><td>{{.Lookup_summary}}</td></tr>
+{{range .Lookup_failures}}   <tr><td>Lookup failed</td><td>{{.}}</td></tr>
+{{end}}{{range .Port_problems}}   <tr><td>Port problem</td><td>{{.}}</td></tr>
+{{end}}{{range .Anomalies}}   <tr><td>Keycount anomaly</td><td>{{.Time.UTC.Format "2006-01-02 15:04Z"}} {{.Severity}}: {{.Message}}</td></tr>
+{{end}}{{if .Fetch_timings}}   <tr><td>Stats fetch</td><td>{{.Fetch_timings}}</td></tr>
+{{end}}{{range .Redirects}}   <tr><td>Redirected to</td><td>{{.}}</td></tr>
+{{end}}   <tr><td>Software</td><td>{{.Software}}</td></tr>
    <tr><td>Software Version</td><td>{{.Version}}</td></tr>
    <tr><td>Web Server</td><td>{{.Web_server}}</td></tr>
    <tr><td>Proxy / via</td><td>{{.Via_info}}</td></tr>
    <tr><td>Key count</td><td>{{.Keycount}}</td></tr>
+   <tr><td>Gossip peers</td><td>{{.Gossip_count}} ({{.Mutual_count}} mutual)</td></tr>
+   <tr><td>Distance from {{.Perspective}}</td><td>{{.Perspective_distance}}</td></tr>
+   <tr><td>Mutual with {{.Perspective}}</td><td>{{.Perspective_mutual}}</td></tr>
 {{if .Mailsync_count}}
    <tr><td rowspan="{{.Mailsync_count}}">Mailsync</td>{{$need_tr := false}}
 {{range .Mailsync}}
@@ -145,18 +163,60 @@ func prepareTemplates() {
 	kPAGE_TEMPLATE_PEER_INFO_PEERS_START := `
   <table class="peers">
    <caption>Peers of <span class="hostname">{{.Peername}}</span></caption>
-   <tr><th>Name</th><th>Common</th><th>Outbound</th><th>Inbound</th></tr>
+   <tr><th>Name</th><th>Common</th><th>Outbound</th><th>Inbound</th><th>Recon</th></tr>
 `
 
 	// name in out common in_only out_only
 	kPAGE_TEMPLATE_PEER_INFO_PEERS := `
-   <tr><td><a href="{{.Ref_url}}">{{.Name}}</a></td><td>{{.Common}}</td><td>{{.Out}}</td><td>{{.In}}</td></tr>
+   <tr><td><a href="{{.Ref_url}}">{{.Name}}</a></td><td>{{.Common}}</td><td>{{.Out}}</td><td>{{.In}}</td><td class="{{.Recon_class}}">{{.Recon}}</td></tr>
 `
 
 	kPAGE_TEMPLATE_PEER_INFO_PEERS_END := " </table>\n"
 
 	kPAGE_TEMPLATE_FOOT_PEER_INFO := " </body>\n</html>\n"
 
+	kPAGE_TEMPLATE_HEAD_MEMBERSHIP_AUDIT := kPAGE_TEMPLATE_BASIC_HEAD + `
+  <link rev="made" href="mailto:{{.Maintainer}}">
+  <title>SKS Membership Audit</title>
+ </head>
+ <body>
+  <h1>SKS Membership Audit</h1>
+{{.Warning}}
+  <div class="explain">
+   Entries in the membership file of <span class="hostname">{{.Us}}</span>, checked against the spidered mesh.
+  </div>
+  <table class="sks membership_audit">
+   <thead><tr><th>Host</th><th>Info</th><th>Software</th><th>Version</th><th>Keys</th><th>Behind</th><th>Problems</th></tr></thead>
+   <tbody>
+`
+
+	kPAGE_TEMPLATE_MEMBERSHIP_AUDIT_ROW := `
+   <tr class="member {{.Rowclass}}{{if .Problems}} problem{{end}}">
+    <td class="hostname">{{.Hostname}}</td>
+    <td class="morelink">{{if .Canonical}}<a href="{{.Info_page}}">&dagger;</a>{{end}}</td>
+    <td class="software">{{.Software}}</td>
+    <td class="version">{{.Version}}</td>
+    <td class="keys">{{.Keycount}}</td>
+    <td class="keys_behind">{{.KeysBehind}}</td>
+    <td class="problems">{{range .Problems}}<span class="audit_{{.Code}}">{{.Detail}}</span><br>{{else}}OK{{end}}</td>
+   </tr>
+`
+
+	kPAGE_TEMPLATE_FOOT_MEMBERSHIP_AUDIT := `
+   </tbody>
+   <caption>{{.Member_count}} membership entries, {{.Problem_count}} with problems</caption>
+  </table>
+{{if .NotInMembership}}
+  <h2>Peering with <span class="hostname">{{.Us}}</span> but not in its membership file</h2>
+  <ul class="not_in_membership">
+{{range .NotInMembership}}   <li class="hostname">{{.}}</li>
+{{end}}  </ul>
+{{end}}
+  <div class="lastupdate">Last scan completed at: {{.LastScanTime}}</div>
+ </body>
+</html>
+`
+
 	serveTemplates = make(map[string]*template.Template, 16)
 	serveTemplates["baduser"] = template.Must(template.New("baduser").Parse(kPAGE_TEMPLATE_BADUSER))
 	serveTemplates["head"] = template.Must(template.New("head").Parse(kPAGE_TEMPLATE_HEAD))
@@ -170,6 +230,9 @@ func prepareTemplates() {
 	serveTemplates["pi_peers"] = template.Must(template.New("pi_peers").Parse(kPAGE_TEMPLATE_PEER_INFO_PEERS))
 	serveTemplates["pi_peers_end"] = template.Must(template.New("pi_peers_end").Parse(kPAGE_TEMPLATE_PEER_INFO_PEERS_END))
 	serveTemplates["pi_foot"] = template.Must(template.New("pi_foot").Parse(kPAGE_TEMPLATE_FOOT_PEER_INFO))
+	serveTemplates["ma_head"] = template.Must(template.New("ma_head").Parse(kPAGE_TEMPLATE_HEAD_MEMBERSHIP_AUDIT))
+	serveTemplates["ma_row"] = template.Must(template.New("ma_row").Parse(kPAGE_TEMPLATE_MEMBERSHIP_AUDIT_ROW))
+	serveTemplates["ma_foot"] = template.Must(template.New("ma_foot").Parse(kPAGE_TEMPLATE_FOOT_MEMBERSHIP_AUDIT))
 }
 
 func init() {
@@ -23,6 +23,7 @@ import (
 	"html/template"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
@@ -32,7 +33,6 @@ const SERVE_PREFIX = "/sks-peers"
 
 const (
 	kHTML_FAVICON = "/favicon.ico"
-	kBUCKET_SIZE  = 3000
 )
 
 const (
@@ -47,6 +47,11 @@ var (
 	statsServersHaveData      *expvar.Int
 	statsServersBadDNS        *expvar.Int
 	statsServersBadData       *expvar.Int
+	statsKeycountReference    *expvar.Int
+	statsServersLagging       *expvar.Int
+	statsServersStalled       *expvar.Int
+	statsServersKeysBehind    *expvar.Map
+	statsAnomalies            *expvar.Map
 )
 
 func init() {
@@ -56,6 +61,11 @@ func init() {
 	statsServersHaveData = expvar.NewInt("collection.servers.havedata")
 	statsServersBadDNS = expvar.NewInt("collection.servers.baddns")
 	statsServersBadData = expvar.NewInt("collection.servers.baddata")
+	statsKeycountReference = expvar.NewInt("collection.keycount.reference")
+	statsServersLagging = expvar.NewInt("collection.servers.lagging")
+	statsServersStalled = expvar.NewInt("collection.servers.stalled")
+	statsServersKeysBehind = expvar.NewMap("collection.servers.keysbehind")
+	statsAnomalies = expvar.NewMap("collection.anomalies")
 }
 
 func setupHttpServer(listen string) *http.Server {
@@ -72,9 +82,18 @@ func setupHttpServer(listen string) *http.Server {
 	http.HandleFunc(SERVE_PREFIX+"/ip-valid", apiIpValidPage)
 	http.HandleFunc(SERVE_PREFIX+"/ip-valid-stats", apiIpValidStatsPage)
 	http.HandleFunc(SERVE_PREFIX+"/hostnames-json", apiHostnamesJsonPage)
+	http.HandleFunc(SERVE_PREFIX+"/membership-audit", apiMembershipAuditPage)
 	http.HandleFunc(SERVE_PREFIX+"/graph-dot", apiGraphDot)
+	http.HandleFunc(SERVE_PREFIX+"/what-if", apiWhatIfPage)
+	http.HandleFunc(SERVE_PREFIX+"/peer-suggest", apiPeerSuggestPage)
+	http.HandleFunc(SERVE_PREFIX+"/consistency", apiConsistencyPage)
+	http.HandleFunc(SERVE_PREFIX+"/recon-audit", apiReconAuditPage)
+	http.HandleFunc(SERVE_PREFIX+"/key-lag", apiKeyLagPage)
+	http.HandleFunc(SERVE_PREFIX+"/anomalies", apiAnomaliesPage)
+	http.HandleFunc(SERVE_PREFIX+"/update-rate", apiUpdateRatePage)
 	http.HandleFunc("/helpz", apiHelpz)
 	http.HandleFunc("/scanstatusz", apiScanStatusz)
+	http.HandleFunc("/exclusionz", apiExclusionz)
 	// net/http/pprof provides /debug/pprof with threads and profiling information
 	// expvar provides /debug/vars (JSON)
 	// MISSING: environz rescanz (internalz) quitz
@@ -101,37 +120,115 @@ func apiHelpz(w http.ResponseWriter, req *http.Request) {
 func apiScanStatusz(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", ContentTypeTextPlain)
 	SpiderDiagnostics(w)
+	if persisted := GetCurrentPersisted(); persisted != nil {
+		fmt.Fprintf(w, "Seeds of last completed scan:\n")
+		for _, seed := range persisted.Seeds {
+			if seed.Usable {
+				fmt.Fprintf(w, "\tOK   %s [%s, distance %d]\n", seed.Hostname, seed.Source, seed.Distance)
+			} else {
+				fmt.Fprintf(w, "\tFAIL %s [%s, distance %d]: %s\n", seed.Hostname, seed.Source, seed.Distance, seed.Problem)
+			}
+		}
+		if len(persisted.Skipped) > 0 {
+			fmt.Fprintf(w, "Hosts skipped in last completed scan: %d\n", len(persisted.Skipped))
+			for _, sh := range persisted.Skipped {
+				fmt.Fprintf(w, "\t%s [distance %d]: %s\n", sh.Hostname, sh.Distance, sh.Reason)
+			}
+		}
+	}
 	fmt.Fprintf(w, "\nDone.\n")
 }
 
+func apiExclusionz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeTextPlain)
+	policy := GetExclusionPolicy()
+	now := time.Now()
+	fmt.Fprintf(w, "Exclusion policy from \"%s\", loaded %s\n", policy.Filename, policy.Loaded.Format(time.RFC3339))
+	for _, rule := range policy.Rules {
+		where := rule.Source
+		if rule.Line != 0 {
+			where = fmt.Sprintf("%s:%d", rule.Source, rule.Line)
+		}
+		expiry := ""
+		switch {
+		case rule.Expired(now):
+			expiry = " EXPIRED " + rule.Expires.Format("2006-01-02")
+		case !rule.Expires.IsZero():
+			expiry = " until " + rule.Expires.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "\t%-4s %s%s [%s]", rule.Kind, rule.Pattern, expiry, where)
+		if rule.Reason != "" {
+			fmt.Fprintf(w, ": %s", rule.Reason)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if len(policy.Warnings) > 0 {
+		fmt.Fprintf(w, "Warnings:\n")
+		for _, warning := range policy.Warnings {
+			fmt.Fprintf(w, "\t%s\n", warning)
+		}
+	}
+}
+
 func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 	//TODO: restore "in progress" reporting
 	//TODO: restore this as trigger for rescan if membership file has changed?
 	//TODO: restore distance
 	//TODO: restore entries which are missing DNS but are configured
+	var err error
+	if err = req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
 	persisted := GetCurrentPersisted()
 	var warning string
 	var display_order = []string{}
+	namespace := genNamespace()
+	namespace["Scanning_active"] = ""
+
+	distanceOf := func(name string) int { return persisted.HostMap[name].Distance }
+	perspective := *flSpiderStartHost
 	if persisted == nil {
 		warning = "Still awaiting data collection"
+	} else if p := req.Form.Get("perspective"); p != "" {
+		if distances, ok := persisted.DistancesFrom(p); ok {
+			perspective = p
+			distanceOf = func(name string) int { return distances[name] }
+			display_order = GenerateDepthSortedBy(persisted.HostMap, distanceOf)
+			namespace["Perspective_param"] = "&perspective=" + url.QueryEscape(p)
+		} else {
+			warning = fmt.Sprintf("Unknown perspective host \"%s\"", p)
+			display_order = persisted.DepthSorted
+		}
 	} else {
 		display_order = persisted.DepthSorted
 	}
-
-	namespace := genNamespace()
-	namespace["Scanning_active"] = ""
+	namespace["Perspective"] = perspective
 
 	// IsZero will hold if persisted loaded from JSON which predates change
 	// that adds the timestamp.
 	if persisted != nil && !persisted.Timestamp.IsZero() {
 		namespace["LastScanTime"] = persisted.Timestamp.UTC().Format("20060102_150405") + "Z"
 	}
+	if persisted != nil && len(persisted.Seeds) > 1 {
+		usable := make([]string, 0, len(persisted.Seeds))
+		unusable := make([]string, 0, len(persisted.Seeds))
+		for _, seed := range persisted.Seeds {
+			if seed.Usable {
+				usable = append(usable, seed.Hostname)
+			} else {
+				unusable = append(unusable, seed.Hostname)
+			}
+		}
+		namespace["Seeds"] = usable
+		namespace["Unusable_seeds"] = unusable
+	}
 
 	namespace["Mesh_count"] = len(display_order)
 	if len(display_order) > 0 {
 		pc := 0
 		for _, name := range display_order {
-			d := persisted.HostMap[name].Distance
+			d := distanceOf(name)
 			if d == 1 {
 				pc += 1
 			} else if d > 1 {
@@ -144,13 +241,13 @@ func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if warning != "" {
-		namespace["warning"] = warning
+		namespace["Warning"] = warning
 	}
 	serveTemplates["head"].Execute(w, namespace)
 
 	for index, host := range display_order {
 		node := persisted.HostMap[host]
-		attributes := make(map[string]interface{}, 11)
+		attributes := make(map[string]interface{}, 14)
 		if len(node.IpList) > 1 {
 			attributes["Rowspan"] = template.HTMLAttr(fmt.Sprintf(" rowspan=\"%d\"", len(node.IpList)))
 		} else {
@@ -164,7 +261,11 @@ func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 		attributes["Hostname"] = host
 		attributes["Sks_info"] = NodeUrl(host, node)
 		attributes["Info_page"] = fmt.Sprintf(SERVE_PREFIX+"/peer-info?peer=%s", host)
-		attributes["Distance"] = node.Distance
+		if p, ok := namespace["Perspective_param"]; ok {
+			attributes["Info_page"] = attributes["Info_page"].(string) + p.(string)
+		}
+		distance := distanceOf(host)
+		attributes["Distance"] = distance
 
 		if node.AnalyzeError != "" {
 			attributes["Error"] = node.AnalyzeError
@@ -172,11 +273,11 @@ func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 			continue
 		}
 
-		switch node.Distance {
+		switch distance {
 		case 0:
 			attributes["Mutual"] = "n/a"
 		case 1:
-			attributes["Mutual"] = persisted.Graph.LabelMutualWithBase(host)
+			attributes["Mutual"] = persisted.Graph.LabelMutualWith(perspective, host)
 		default:
 			attributes["Mutual"] = "-"
 		}
@@ -187,6 +288,21 @@ func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 		}
 		attributes["Version"] = node.Version
 		attributes["Keycount"] = node.Keycount
+		attributes["KeysBehind"] = node.KeysBehind
+		switch {
+		case node.SyncStalled:
+			attributes["Sync_state"] = "stalled"
+			attributes["Sync_note"] = fmt.Sprintf("keycount unchanged for %d scans while the mesh grew", node.FrozenScans)
+		case node.KeysBehind > *flKeysLagWarn:
+			attributes["Sync_state"] = "lagging"
+			attributes["Sync_note"] = fmt.Sprintf("more than %d keys behind the mesh", *flKeysLagWarn)
+		case node.NoUpdates:
+			attributes["Sync_state"] = "no_updates"
+			attributes["Sync_note"] = "no new or updated keys in the latest day, while the mesh had some"
+		default:
+			attributes["Sync_state"] = ""
+			attributes["Sync_note"] = ""
+		}
 		attributes["Web_server"] = node.ServerHeader
 		if node.ViaHeader != "" {
 			attributes["Via_info"] = fmt.Sprintf("✓ [%s]", node.ViaHeader)
@@ -196,6 +312,14 @@ func apiPeersPage(w http.ResponseWriter, req *http.Request) {
 		for n, ip := range node.IpList {
 			attributes["Ip"] = ip
 			attributes["Geo"] = persisted.IPCountryMap[ip]
+			attributes["Ip_state"] = ""
+			attributes["Ip_probe"] = ""
+			if probe, ok := node.IPStatus[ip]; ok {
+				attributes["Ip_probe"] = probe.String()
+				if !probe.OK() {
+					attributes["Ip_state"] = "failed"
+				}
+			}
 			if n == 0 {
 				serveTemplates["host"].Execute(w, attributes)
 			} else {
@@ -241,18 +365,47 @@ func apiPeerInfoPage(w http.ResponseWriter, req *http.Request) {
 
 	namespace["Keycount"] = node.Keycount
 	namespace["Version"] = node.Version
-	if node.Software != "" {
-		namespace["Software"] = node.Software
-	} else {
-		namespace["Software"] = defaultSoftware
+	namespace["Software"] = node.SoftwareName()
+	ipInfo := make([]string, len(node.IpList))
+	for i, ip := range node.IpList {
+		ipInfo[i] = "[" + ip + "]"
+		if probe, ok := node.IPStatus[ip]; ok {
+			ipInfo[i] += " " + probe.String()
+		}
 	}
-	namespace["Ips"] = "[" + strings.Join(node.IpList, "], [") + "]"
+	namespace["Ips"] = strings.Join(ipInfo, ", ")
+	if node.Timings != nil {
+		namespace["Fetch_timings"] = node.Timings.String()
+	}
+	namespace["Redirects"] = node.Redirects
+	namespace["Hkp_port"] = node.Port
+	namespace["Advertised_ports"] = fmt.Sprintf("HTTP %d, recon %d", node.AdvertisedHkpPort, node.AdvertisedReconPort)
+	namespace["Port_problems"] = node.PortProblems
+	namespace["Anomalies"] = RecentAnomalies(peer, SeverityInfo)
+	namespace["Recon_probe"] = node.Recon.String()
+	namespace["Recon_settings"] = reconSettingsOf(node).String()
+	namespace["Lookup_summary"], namespace["Lookup_failures"] = node.lookupSummary()
 	namespace["Mailsync"] = node.MailsyncPeers
 	namespace["Mailsync_count"] = len(node.MailsyncPeers)
 	namespace["Web_server"] = node.ServerHeader
 	namespace["Via_info"] = node.ViaHeader
 	namespace["Peer_statsurl"] = node.Url()
 
+	perspective := req.Form.Get("perspective")
+	if perspective == "" {
+		perspective = *flSpiderStartHost
+	}
+	namespace["Perspective"] = perspective
+	if distances, ok := persisted.DistancesFrom(perspective); ok {
+		namespace["Perspective_distance"] = distances[peer]
+		namespace["Perspective_mutual"] = persisted.Graph.LabelMutualWith(perspective, peer)
+	} else {
+		namespace["Perspective_distance"] = "?"
+		namespace["Perspective_mutual"] = "?"
+	}
+	namespace["Gossip_count"] = len(node.GossipPeerList)
+	namespace["Mutual_count"] = len(persisted.Graph.MutualPeersOf(strings.ToLower(peer)))
+
 	peer_list := persisted.Graph.AllPeersOf(node.Hostname)
 
 	serveTemplates["pi_head"].Execute(w, namespace)
@@ -260,20 +413,28 @@ func apiPeerInfoPage(w http.ResponseWriter, req *http.Request) {
 	serveTemplates["pi_peers_start"].Execute(w, namespace)
 
 	for _, other := range peer_list {
-		attributes := make(map[string]interface{}, 5)
+		attributes := make(map[string]interface{}, 7)
 		attributes["Name"] = other
 		attributes["Ref_url"] = NodeUrl(other, persisted.HostMap[other])
 		out := persisted.Graph.ExistsLink(peer, other)
 		in := persisted.Graph.ExistsLink(other, peer)
 		common := out && in
 		attributes["Out"] = out
+		attributes["Recon_class"] = ""
 		if _, ok := persisted.AliasMap[other]; !ok {
 			// peer not successfully polled
 			attributes["In"] = "?"
 			attributes["Common"] = "?"
+			attributes["Recon"] = "?"
 		} else {
 			attributes["In"] = in
 			attributes["Common"] = common
+			if problems := persisted.linkProblems(peer, other, out, in); len(problems) > 0 {
+				attributes["Recon"] = strings.Join(problems, "; ")
+				attributes["Recon_class"] = "recon_incompatible"
+			} else {
+				attributes["Recon"] = "ok"
+			}
 		}
 		serveTemplates["pi_peers"].Execute(w, attributes)
 	}
@@ -345,3 +506,81 @@ func apiHostnamesJsonPage(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", contentType)
 	fmt.Fprintf(w, "{ \"hostnames\": %s }\n", b)
 }
+
+func apiMembershipAuditPage(w http.ResponseWriter, req *http.Request) {
+	var err error
+	if err = req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+	hostList, err := GetMembershipHosts()
+	if err != nil {
+		Log.Printf("Failed to load membership: %s", err)
+		http.Error(w, "Problem loading membership file", http.StatusServiceUnavailable)
+		return
+	}
+
+	audit := AuditMembership(persisted, *flSpiderStartHost, hostList)
+
+	if _, ok := req.Form["json"]; ok {
+		writeJsonResponse(w, req, audit)
+		return
+	}
+
+	namespace := genNamespace()
+	namespace["Us"] = audit.Us
+	namespace["NotInMembership"] = audit.NotInMembership
+	namespace["Member_count"] = len(audit.Entries)
+	if !persisted.Timestamp.IsZero() {
+		namespace["LastScanTime"] = persisted.Timestamp.UTC().Format("20060102_150405") + "Z"
+	}
+	problemCount := 0
+	for _, entry := range audit.Entries {
+		if len(entry.Problems) > 0 {
+			problemCount++
+		}
+	}
+	namespace["Problem_count"] = problemCount
+
+	serveTemplates["ma_head"].Execute(w, namespace)
+	for index, entry := range audit.Entries {
+		attributes := make(map[string]interface{}, 10)
+		if index%2 == 0 {
+			attributes["Rowclass"] = "even"
+		} else {
+			attributes["Rowclass"] = "odd"
+		}
+		attributes["Hostname"] = entry.Hostname
+		attributes["Canonical"] = entry.Canonical
+		attributes["Info_page"] = fmt.Sprintf(SERVE_PREFIX+"/peer-info?peer=%s", entry.Canonical)
+		attributes["Software"] = entry.Software
+		attributes["Version"] = entry.Version
+		attributes["Keycount"] = entry.Keycount
+		attributes["KeysBehind"] = entry.KeysBehind
+		attributes["Problems"] = entry.Problems
+		serveTemplates["ma_row"].Execute(w, attributes)
+	}
+	serveTemplates["ma_foot"].Execute(w, namespace)
+}
+
+// writeJsonResponse is for the machine-readable pages; as with hostnames-json,
+// a "textplain" parameter switches the Content-Type for easier viewing.
+func writeJsonResponse(w http.ResponseWriter, req *http.Request, v interface{}) {
+	b, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		Log.Printf("Failed to marshal %T to JSON: %s", v, err)
+		http.Error(w, "JSON encoding glitch", http.StatusInternalServerError)
+		return
+	}
+	contentType := ContentTypeJson
+	if _, ok := req.Form["textplain"]; ok {
+		contentType = ContentTypeTextPlain
+	}
+	w.Header().Set("Content-Type", contentType)
+	fmt.Fprintf(w, "%s\n", b)
+}
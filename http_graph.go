@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -51,12 +53,136 @@ func (ga GraphvizAttributes) String() string {
 	return buf.String()
 }
 
+// graphSelection restricts the graph export to a subgraph of the mesh.
+// A nil hosts map means that every host is selected.
+type graphSelection struct {
+	hosts      map[string]bool
+	mutualOnly bool
+	clusters   bool
+}
+
+func (gs *graphSelection) restrictTo(names map[string]bool) {
+	if gs.hosts == nil {
+		gs.hosts = names
+		return
+	}
+	for name := range gs.hosts {
+		if !names[name] {
+			delete(gs.hosts, name)
+		}
+	}
+}
+
+func (gs *graphSelection) Has(hostname string) bool {
+	if gs.hosts == nil {
+		return true
+	}
+	return gs.hosts[strings.ToLower(hostname)]
+}
+
+// newGraphSelection handles the query parameters which select a subgraph:
+//
+//	host=NAME depth=N  -- the neighbourhood of a host, to depth N (default 1)
+//	countries=XX,YY    -- hosts with an IP in one of these countries
+//	software=NAME      -- hosts running this software (SKS, Hockeypuck, ...)
+//	minimum_version=V  -- hosts running at least this version
+//	version=V          -- hosts running exactly this version
+//	mutual             -- only show links which both sides have configured
+//	clusters           -- group the hosts by country
+func newGraphSelection(req *http.Request, persisted *PersistedHostInfo) (*graphSelection, error) {
+	gs := &graphSelection{}
+	if _, ok := req.Form["mutual"]; ok {
+		gs.mutualOnly = true
+	}
+	if _, ok := req.Form["clusters"]; ok {
+		gs.clusters = true
+	}
+
+	if host := req.Form.Get("host"); host != "" {
+		depth := 1
+		if d := req.Form.Get("depth"); d != "" {
+			var err error
+			depth, err = strconv.Atoi(d)
+			if err != nil || depth < 0 {
+				return nil, fmt.Errorf("Bad 'depth' parameter %q", d)
+			}
+		}
+		if _, ok := persisted.Graph.Canonical(host); !ok {
+			return nil, fmt.Errorf("Unknown host %q", host)
+		}
+		names := make(map[string]bool)
+		for name := range persisted.Graph.Neighbourhood(host, depth, gs.mutualOnly) {
+			names[name] = true
+		}
+		gs.restrictTo(names)
+	}
+
+	var predicates []func(*SksNode) bool
+	if _, ok := req.Form["countries"]; ok {
+		countries := NewCountrySet(req.Form.Get("countries"))
+		predicates = append(predicates, func(node *SksNode) bool {
+			for _, ip := range node.IpList {
+				if geo, ok := persisted.IPCountryMap[ip]; ok && countries.HasCountry(geo) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if software := req.Form.Get("software"); software != "" {
+		predicates = append(predicates, func(node *SksNode) bool {
+			return strings.EqualFold(node.SoftwareName(), software)
+		})
+	}
+	if mv := req.Form.Get("minimum_version"); mv != "" {
+		minimumVersion := NewSksVersion(mv)
+		if minimumVersion == nil {
+			return nil, fmt.Errorf("Bad 'minimum_version' parameter %q", mv)
+		}
+		predicates = append(predicates, func(node *SksNode) bool {
+			v := NewSksVersion(node.Version)
+			return v != nil && v.IsAtLeast(minimumVersion)
+		})
+	}
+	if version := req.Form.Get("version"); version != "" {
+		predicates = append(predicates, func(node *SksNode) bool {
+			return node.Version == version
+		})
+	}
+
+	if len(predicates) > 0 {
+		names := make(map[string]bool, len(persisted.HostMap))
+	NODES:
+		for hostname, node := range persisted.HostMap {
+			for _, p := range predicates {
+				if !p(node) {
+					continue NODES
+				}
+			}
+			names[strings.ToLower(hostname)] = true
+		}
+		gs.restrictTo(names)
+	}
+
+	return gs, nil
+}
+
 func apiGraphDot(w http.ResponseWriter, req *http.Request) {
 	persisted := GetCurrentPersisted()
 	if persisted == nil {
 		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
 		return
 	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	selection, err := newGraphSelection(req, persisted)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	timestamp := time.Now().UTC().Format("20060102_150405") + "Z"
 	filename := fmt.Sprintf("sks-peers-%s.dot", timestamp)
 	w.Header().Set("Content-Type", "text/x-graphviz; charset=UTF-8")
@@ -80,25 +206,58 @@ func apiGraphDot(w http.ResponseWriter, req *http.Request) {
 	// important than another.  Not even the seed we happened to use.
 
 	fmt.Fprintf(w, "digraph sks {\n")
+	byCountry := make(map[string][]string)
+	countries := make([]string, 0, 50)
 	for _, hostname := range persisted.Sorted {
-		attributes := make(GraphvizAttributes)
-		node := persisted.HostMap[hostname]
-		attributes["depth"] = node.Distance
-		if node.AnalyzeError != "" {
-			attributes["error"] = node.AnalyzeError
-		} else {
-			attributes["software"] = node.Software
-			attributes["version"] = node.Version
-			attributes["keycount"] = node.Keycount
+		if !selection.Has(hostname) {
+			continue
 		}
-		for n, ip := range node.IpList {
-			attributes[fmt.Sprintf("ip%d", n)] = ip
+		country := ""
+		if selection.clusters {
+			country = persisted.IPCountryMap.CountryForNode(persisted.HostMap[hostname])
+		}
+		if _, ok := byCountry[country]; !ok {
+			countries = append(countries, country)
+		}
+		byCountry[country] = append(byCountry[country], hostname)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		indent := "\t"
+		if country != "" {
+			fmt.Fprintf(w, "\tsubgraph \"cluster_%s\" {\n\t\tlabel=\"%s\";\n", country, country)
+			indent = "\t\t"
+		}
+		for _, hostname := range byCountry[country] {
+			attributes := make(GraphvizAttributes)
+			node := persisted.HostMap[hostname]
+			attributes["depth"] = node.Distance
+			attributes["mutual_depth"] = node.MutualDistance
+			if node.AnalyzeError != "" {
+				attributes["error"] = node.AnalyzeError
+			} else {
+				attributes["software"] = node.Software
+				attributes["version"] = node.Version
+				attributes["keycount"] = node.Keycount
+			}
+			for n, ip := range node.IpList {
+				attributes[fmt.Sprintf("ip%d", n)] = ip
+			}
+			fmt.Fprintf(w, "%s\"%s\" [%s];\n", indent, hostname, attributes)
+		}
+		if country != "" {
+			fmt.Fprintf(w, "\t}\n")
 		}
-		fmt.Fprintf(w, "\t\"%s\" [%s];\n", hostname, attributes)
 	}
 	var directionality string
 	for _, hostname := range persisted.Sorted {
+		if !selection.Has(hostname) {
+			continue
+		}
 		for peername := range persisted.Graph.Outbound(hostname) {
+			if !selection.Has(peername) {
+				continue
+			}
 			backwards := fmt.Sprintf("%s:%s", peername, hostname)
 			if shown[backwards] {
 				continue
@@ -106,6 +265,8 @@ func apiGraphDot(w http.ResponseWriter, req *http.Request) {
 			if persisted.Graph.ExistsLink(peername, hostname) {
 				directionality = " dir=both"
 				shown[backwards] = true
+			} else if selection.mutualOnly {
+				continue
 			} else {
 				directionality = ""
 			}
@@ -17,11 +17,8 @@
 package sks_spider
 
 import (
-	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -34,10 +31,9 @@ func apiIpValidPage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	var (
-		showStats        bool
-		emitJson         bool
-		limitToProxies   bool
-		limitToCountries CountrySet
+		showStats bool
+		emitJson  bool
+		policy    SelectionPolicy
 	)
 	if _, ok := req.Form["stats"]; ok {
 		showStats = true
@@ -46,410 +42,213 @@ func apiIpValidPage(w http.ResponseWriter, req *http.Request) {
 		emitJson = true
 	}
 	if _, ok := req.Form["proxies"]; ok {
-		limitToProxies = true
+		policy.ProxiesOnly = true
 	}
-	if _, ok := req.Form["countries"]; ok {
-		limitToCountries = NewCountrySet(req.Form.Get("countries"))
-	}
-
-	statsList := make([]string, 0, 100)
-	Statsf := func(s string, v ...interface{}) {
-		statsList = append(statsList, fmt.Sprintf(s, v...))
-	}
-
-	var (
-		abortMessage func(string)
-		doShowStats  func()
-		contentType  string
-	)
-
-	if emitJson {
-		contentType = ContentTypeJson
-		if _, ok := req.Form["textplain"]; ok {
-			contentType = ContentTypeTextPlain
-		}
-		doShowStats = func() {
-			b, err := json.Marshal(statsList)
-			if err != nil {
-				Log.Printf("Unable to JSON marshal stats: %s", err)
-				return
-			}
-			fmt.Fprintf(w, "\"stats\": %s\n", b)
-		}
-		abortMessage = func(s string) {
-			fmt.Fprintf(w, "{\n")
-			if showStats {
-				doShowStats()
-				fmt.Fprintf(w, ", ")
-			}
-			fmt.Fprintf(w, `"status": { "status": "INVALID", "count": 0, "reason": "%s" }`, s)
-			fmt.Fprintf(w, "\n}\n")
-		}
-	} else {
-		contentType = ContentTypeTextPlain
-		doShowStats = func() {
-			for _, l := range statsList {
-				fmt.Fprintf(w, "STATS: %s\n", l)
-			}
-		}
-		abortMessage = func(s string) {
-			if showStats {
-				doShowStats()
-			}
-			fmt.Fprintf(w, "IP-Gen/1.1: status=INVALID count=0 reason=%s\n.\n", s)
+	if req.Form.Get("lookup_ok") == "1" {
+		// With no canary keys nothing is probed and every address would fail.
+		if canaries, _ := parseCanaryKeys(*flCanaryKeys); len(canaries) == 0 {
+			http.Error(w, "lookup_ok=1 needs -canary-keys to be configured", http.StatusBadRequest)
+			return
 		}
+		policy.LookupOK = true
 	}
-	w.Header().Set("Content-Type", contentType)
-
-	persisted := GetCurrentPersisted()
-	if persisted == nil {
-		abortMessage("first_scan")
-		return
+	if _, ok := req.Form["countries"]; ok {
+		policy.Countries = NewCountrySet(req.Form.Get("countries"))
 	}
-
-	var minimumVersion *SksVersion = nil
-	mvReq := req.Form.Get("minimum_version")
-	if mvReq != "" {
-		tmp := NewSksVersion(mvReq)
-		minimumVersion = tmp
+	if mvReq := req.Form.Get("minimum_version"); mvReq != "" {
+		policy.MinimumVersion = NewSksVersion(mvReq)
 	}
-
-	var (
-		// for stats, we avoid double-weighting dual-stack boxes by working with
-		// just one IP per box, but then later deal with all the IPs for filtering.
-		ips_one_per_server = make(map[string]int, len(persisted.HostMap)*2)
-		ips_all            = make(map[string]int, len(persisted.HostMap)*2)
-	)
-
-	var (
-		count_servers_1010            int
-		count_servers_too_old         int
-		count_servers_unwanted_server int
-		count_servers_wrong_country   int
-		ips_skip_1010                 = newSortedSet()
-		ips_too_old                   = newSortedSet()
-		ips_unwanted_server           = newSortedSet()
-		ips_wrong_country             = newSortedSet()
-	)
-
-	for _, name := range persisted.Sorted {
-		node := persisted.HostMap[name]
-		var (
-			skip_this_1010     = false
-			skip_this_age      = false
-			skip_this_nonproxy = false
-			skip_this_country  = false
-		)
-		if node.Keycount <= 1 {
-			Statsf("dropping server <%s> with %d keys", name, node.Keycount)
-			continue
-		}
-
-		if string(node.Version) == "1.0.10" {
-			skip_this_1010 = true
-			//ips_skip_1010.Insert(name) // nope, IPs
-			count_servers_1010 += 1
-		}
-
-		if minimumVersion != nil {
-			thisVersion := NewSksVersion(node.Version)
-			if thisVersion == nil || !thisVersion.IsAtLeast(minimumVersion) {
-				skip_this_age = true
-				count_servers_too_old += 1
-			}
-		}
-
-		if limitToProxies && node.ViaHeader == "" {
-			server := strings.ToLower(strings.SplitN(node.ServerHeader, "/", 2)[0])
-			if _, ok := serverHeadersNative[server]; ok {
-				skip_this_nonproxy = true
-				count_servers_unwanted_server += 1
-			}
-		}
-
-		if limitToCountries.Initialized() {
-			var keep bool
-			for _, ip := range node.IpList {
-				geo, ok := persisted.IPCountryMap[ip]
-				if ok && limitToCountries.HasCountry(geo) {
-					keep = true
-				}
-			}
-			if !keep {
-				skip_this_country = true
-				count_servers_wrong_country += 1
-			}
+	if nt, ok := req.Form["threshold"]; ok {
+		i, ok2 := strconv.Atoi(nt[0])
+		if ok2 == nil && i > 0 {
+			policy.Threshold = i
 		}
-
-		if len(node.IpList) > 0 {
-			ips_one_per_server[node.IpList[0]] = node.Keycount
-			for _, ip := range node.IpList {
-				ips_all[ip] = node.Keycount
-				if skip_this_1010 {
-					ips_skip_1010.Insert(ip)
-				}
-				if skip_this_age {
-					ips_too_old.Insert(ip)
-				}
-				if skip_this_nonproxy {
-					ips_unwanted_server.Insert(ip)
-				}
-				if skip_this_country {
-					ips_wrong_country.Insert(ip)
-				}
-			}
+	}
+	if alg := req.Form.Get("alg"); alg != "" {
+		if PoolAlgorithmByTag(alg) == nil {
+			http.Error(w, fmt.Sprintf("Unknown alg %q; have: %s", alg, strings.Join(PoolAlgorithmTags(), ", ")), http.StatusBadRequest)
+			return
 		}
-
+		policy.Algorithm = alg
 	}
-
-	// We want to discard statistic-distorting outliers, then of what remains,
-	// discard those too far away from "normal", but we really want the "best"
-	// servers to be our guide, so 1 std-dev of the second-highest remaining
-	// value should be safe; in fact, we'll hardcode a limit of how far below.
-	// To discard, find mode size (knowing that value can be split across two
-	// buckets) and discard more than five stddevs from mode.  The bucketing
-	// should be larger than the distance from desired value so that the mode
-	// is only split across two buckets, if we assume enough servers that a
-	// small number will be down, most will be valid-if-large-enough, so that
-	// splitting the count across two buckets won't let the third-best value win
-
-	// This is barely-modified from Python, just enough to translate language, not idioms
-	// This was ... "much easier" with list comprehensions in Python
-	var buckets = make(map[int][]int, 40)
-	for _, count := range ips_one_per_server {
-		bucket := int(count / kBUCKET_SIZE)
-		if _, ok := buckets[bucket]; !ok {
-			buckets[bucket] = make([]int, 0, 20)
+	if pct := req.Form.Get("percentile"); pct != "" {
+		f, err := strconv.ParseFloat(pct, 64)
+		if err != nil || f <= 0 || f > 100 {
+			http.Error(w, "Bad percentile, must be in (0, 100]", http.StatusBadRequest)
+			return
 		}
-		buckets[bucket] = append(buckets[bucket], count)
+		policy.Percentile = f
 	}
-	if len(buckets) == 0 {
-		abortMessage("broken_no_buckets")
-		return
+	explain := req.Form.Get("explain")
+	_, wantTrace := req.Form["trace"]
+	policy.Trace = explain != "" || wantTrace
+	persisted := GetCurrentPersisted()
+	selector := NewPoolSelector(persisted)
+	if bs := req.Form.Get("bucket"); bs != "" {
+		i, err := strconv.Atoi(bs)
+		if err != nil || i < 1 {
+			http.Error(w, "Bad bucket, must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		selector.BucketSize = i
 	}
 
-	var largest_bucket int
-	var largest_bucket_len int
-	for k := range buckets {
-		if len(buckets[k]) > largest_bucket_len {
-			largest_bucket = k
-			largest_bucket_len = len(buckets[k])
-		}
+	result := selector.Select(policy)
+	var comparisons []*algorithmComparison
+	if _, ok := req.Form["compare"]; ok {
+		p := policy
+		p.Trace = false
+		comparisons = compareAlgorithms(selector, p, result)
 	}
-	first_n := len(buckets[largest_bucket])
-	var first_sum int
-	for _, v := range buckets[largest_bucket] {
-		first_sum += v
+	trace := result.Trace
+	if explain != "" {
+		trace = ExplainTrace(persisted, trace, explain)
 	}
-	first_mean := float64(first_sum) / float64(first_n)
-	var first_sd float64
-	for _, v := range buckets[largest_bucket] {
-		d := float64(v) - first_mean
-		first_sd += d * d
+	if result.Status == SelectionComplete {
+		Log.Printf("ip-valid: Yielding %d of %d values", len(result.IPs), result.Considered)
 	}
-	first_sd = math.Sqrt(first_sd / float64(first_n))
-	first_bounds_min := int(first_mean - 5*first_sd)
-	first_bounds_max := int(first_mean + 5*first_sd)
 
-	first_ips_list := make([]string, 0, len(ips_one_per_server))
-	for ip := range ips_one_per_server {
-		if first_bounds_min <= ips_all[ip] && ips_all[ip] <= first_bounds_max {
-			first_ips_list = append(first_ips_list, ip)
+	statusD := make(map[string]interface{}, 16)
+	statusD["status"] = result.Status
+	statusD["count"] = len(result.IPs)
+	if result.Status != SelectionComplete {
+		statusD["reason"] = result.Reason
+	} else {
+		statusD["tags"] = result.Tags
+		if policy.MinimumVersion != nil {
+			statusD["minimum_version"] = policy.MinimumVersion.String()
 		}
-	}
-	first_ips_alllist := make([]string, 0, len(ips_all))
-	for ip := range ips_all {
-		if first_bounds_min <= ips_all[ip] && ips_all[ip] <= first_bounds_max {
-			first_ips_alllist = append(first_ips_alllist, ip)
+		if policy.ProxiesOnly {
+			statusD["proxies"] = "1"
 		}
+		if policy.Countries.Initialized() {
+			statusD["countries"] = policy.Countries.String()
+		}
+		statusD["minimum"] = result.Threshold
+		//TODO: change now to be the time the scan finished
+		statusD["collected"] = time.Now().UTC().Format("2006-01-02T15:04:05") + "Z"
 	}
-	var second_mean, second_sd float64
-	first_ips := make(map[string]int, len(first_ips_list))
-	for _, ip := range first_ips_list {
-		first_ips[ip] = ips_all[ip]
-		second_mean += float64(ips_all[ip])
-	}
-	first_ips_all := make(map[string]int, len(first_ips_alllist))
-	for _, ip := range first_ips_alllist {
-		first_ips_all[ip] = ips_all[ip]
-	}
-	second_mean /= float64(len(first_ips_list))
-	for _, v := range first_ips {
-		d := float64(v) - second_mean
-		second_sd += d * d
-	}
-	second_sd = math.Sqrt(second_sd / float64(len(first_ips_list)))
 
-	if showStats {
-		Statsf("have %d servers in %d buckets (%d ips total)", len(ips_one_per_server), len(buckets), len(ips_all))
-		bucket_sizes := make([]int, 0, len(buckets))
-		for k := range buckets {
-			bucket_sizes = append(bucket_sizes, k)
+	if emitJson {
+		response := make(map[string]interface{}, 3)
+		if showStats {
+			response["stats"] = result.Stats
 		}
-		sort.Ints(bucket_sizes)
-		for _, b := range bucket_sizes {
-			Statsf("%6d: %s", b, strings.Repeat("*", len(buckets[b])))
+		response["status"] = statusD
+		if result.Status == SelectionComplete {
+			response["ips"] = result.IPs
 		}
-		Statsf("largest bucket is %d with %d entries", largest_bucket, first_n)
-		Statsf("bucket size %d means bucket %d is [%d, %d)", kBUCKET_SIZE, largest_bucket,
-			kBUCKET_SIZE*largest_bucket, kBUCKET_SIZE*(largest_bucket+1))
-		Statsf("largest bucket: mean=%f sd=%f", first_mean, first_sd)
-		Statsf("first bounds: [%d, %d]", first_bounds_min, first_bounds_max)
-		Statsf("have %d servers within bounds, mean value %f sd=%f", len(first_ips_list), second_mean, second_sd)
-	}
-
-	if second_mean < float64(*flKeysSanityMin) {
-		Statsf("mean %f < %d", second_mean, *flKeysSanityMin)
-		abortMessage("broken_data")
+		if comparisons != nil {
+			response["compare"] = comparisons
+		}
+		if policy.Trace {
+			response["trace"] = trace
+		}
+		writeJsonResponse(w, req, response)
 		return
 	}
-	threshold_base_index := len(first_ips) - 2
-	if threshold_base_index < 0 {
-		threshold_base_index = 0
-	}
-	threshold_candidates := make([]int, 0, len(first_ips))
-	for _, count := range first_ips {
-		threshold_candidates = append(threshold_candidates, count)
-	}
-	sort.Ints(threshold_candidates)
-	var threshold int = threshold_candidates[threshold_base_index] - (*flKeysDailyJitter + int(second_sd))
 
+	w.Header().Set("Content-Type", ContentTypeTextPlain)
 	if showStats {
-		Statsf("Second largest count within bounds: %d", threshold_candidates[threshold_base_index])
-		Statsf("threshold: %d", threshold)
-	}
-
-	if nt, ok := req.Form["threshold"]; ok {
-		i, ok2 := strconv.Atoi(nt[0])
-		if ok2 == nil && i > 0 {
-			Statsf("Overriding threshold from CGI parameter; %d -> %d", threshold, i)
-			threshold = i
+		for _, l := range result.Stats {
+			fmt.Fprintf(w, "STATS: %s\n", l)
 		}
 	}
-
-	ips := make([]string, 0, len(first_ips_all))
-	for ip, count := range first_ips_all {
-		if count >= threshold {
-			ips = append(ips, ip)
+	if explain != "" && len(trace) == 0 {
+		if persisted == nil {
+			// no scan yet, so we know nothing of it either way
+			fmt.Fprintf(w, "EXPLAIN: %s cannot be explained: %s\n", explain, result.Reason)
+		} else {
+			fmt.Fprintf(w, "EXPLAIN: %s is not a candidate: unknown to the mesh\n", explain)
 		}
 	}
-	if len(ips) == 0 {
-		Statsf("No IPs above threshold %d", threshold)
-		abortMessage("threshold_too_high")
-		return
-	}
-
-	filterOut := func(rationale string, eliminate sortedSet, eliminate_server_count int, candidates []string) []string {
-		alreadyDropped := newSortedSet()
-		for ip := range eliminate.Data() {
-			alreadyDropped.Insert(ip)
-		}
-		for _, ip := range candidates {
-			alreadyDropped.Remove(ip)
-		}
-		ips = make([]string, 0, len(candidates))
-		for _, ip := range candidates {
-			if !eliminate.Contains(ip) {
-				ips = append(ips, ip)
+	if policy.Trace {
+		for _, t := range trace {
+			for _, step := range t.Steps {
+				outcome := "ok"
+				if !step.Passed {
+					outcome = "FAIL"
+				}
+				fmt.Fprintf(w, "EXPLAIN: %s <%s> %s %s: %s\n", t.IP, t.Hostname, step.Stage, outcome, step.Detail)
 			}
+			fmt.Fprintf(w, "EXPLAIN: %s <%s> verdict: %s\n", t.IP, t.Hostname, t.Verdict)
 		}
-		Statsf("dropping all %d servers %s, for %d possible IPs but %d of those already dropped",
-			eliminate_server_count, rationale, eliminate.Len(), alreadyDropped.Len())
-		return ips
 	}
-
-	ips = filterOut("running version v1.0.10", ips_skip_1010, count_servers_1010, ips)
-	if len(ips) == 0 {
-		abortMessage("No_servers_left_after_v1.0.10_filter")
-		return
-	}
-
-	if minimumVersion != nil {
-		ips = filterOut(fmt.Sprintf("running version < v%s", minimumVersion), ips_too_old, count_servers_too_old, ips)
-		if len(ips) == 0 {
-			abortMessage(fmt.Sprintf("No_servers_left_after_minimum_version_filter_(v%s)", minimumVersion))
-			return
+	for _, c := range comparisons {
+		fmt.Fprintf(w, "STATS: [%s] status=%s count=%d minimum=%d%s\n", c.Algorithm, c.Status, c.Count, c.Minimum, c.reasonSuffix())
+		for _, l := range c.Stats {
+			fmt.Fprintf(w, "STATS: [%s] %s\n", c.Algorithm, l)
 		}
 	}
-
-	if limitToCountries.Initialized() {
-		ips = filterOut(fmt.Sprintf("not in countries [%s]", limitToCountries), ips_wrong_country, count_servers_wrong_country, ips)
-		if len(ips) == 0 {
-			abortMessage(fmt.Sprintf("No_servers_left_after_country_filter_[%s]", limitToCountries))
-			return
-		}
+	if result.Status != SelectionComplete {
+		fmt.Fprintf(w, "IP-Gen/1.1: status=%s count=0 reason=%s\n.\n", result.Status, result.Reason)
+		return
 	}
-
-	if limitToProxies {
-		ips = filterOut("not behind a web-proxy", ips_unwanted_server, count_servers_unwanted_server, ips)
-		if len(ips) == 0 {
-			abortMessage("No_servers_left_after_proxies_filter")
-			return
+	fmt.Fprintf(w, "IP-Gen/1.1:")
+	for k, v := range statusD {
+		var vstr string
+		switch v.(type) {
+		case int:
+			vstr = strconv.Itoa(v.(int))
+		case []string:
+			vstr = strings.Join(v.([]string), ",")
+		default:
+			vstr = fmt.Sprintf("%s", v)
 		}
+		fmt.Fprintf(w, " %s=%s", k, vstr)
 	}
+	fmt.Fprintf(w, "\n")
+	for _, ip := range result.IPs {
+		fmt.Fprintf(w, "%s\n", ip)
+	}
+	fmt.Fprintf(w, ".\n")
+}
 
-	//TODO: change now to be the time the scan finished
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05") + "Z"
-	count := len(ips)
-	Log.Printf("ip-valid: Yielding %d of %d values", count, len(ips_all))
+type algorithmComparison struct {
+	Algorithm string   `json:"alg"`
+	Status    string   `json:"status"`
+	Reason    string   `json:"reason,omitempty"`
+	Count     int      `json:"count"`
+	Minimum   int      `json:"minimum"`
+	Agree     int      `json:"agree"` // IPs also chosen by the requested algorithm
+	Stats     []string `json:"stats"`
+}
 
-	// The tags are public statements; history:
-	//   skip 1.0.10 -> skip_1010, because of lookup problems biting gnupg
-	//   alg_1 used a fixed threshold (too small to deal with jitter)
-	//   alg_2 used stddev+jitter
-	//   alg_3 fixed maximum bucket selection (was a code bug)
-	//   alg_4 stopped double-counting servers with multiple IP addresses
-	//   alg_5 keep 1.0.10 servers for long enough to calculate stats, drop afterwards
-	statusD := make(map[string]interface{}, 16)
-	statusD["status"] = "COMPLETE"
-	statusD["count"] = count
-	statusD["tags"] = []string{"skip_1010", "alg_5"}
-	if minimumVersion != nil {
-		statusD["minimum_version"] = minimumVersion.String()
+func (c *algorithmComparison) reasonSuffix() string {
+	if c.Reason == "" {
+		return fmt.Sprintf(" agree=%d", c.Agree)
 	}
-	if limitToProxies {
-		statusD["proxies"] = "1"
-	}
-	if limitToCountries.Initialized() {
-		statusD["countries"] = limitToCountries.String()
-	}
-	statusD["minimum"] = threshold
-	statusD["collected"] = timestamp
+	return " reason=" + c.Reason
+}
 
-	if emitJson {
-		fmt.Fprintf(w, "{\n")
-		if showStats {
-			doShowStats()
-			fmt.Fprintf(w, ", ")
+// compareAlgorithms runs the same request through every algorithm, the
+// requested one included, so that their choices can be read side by side.
+func compareAlgorithms(selector *PoolSelector, policy SelectionPolicy, chosen *SelectionResult) []*algorithmComparison {
+	picked := make(map[string]bool, len(chosen.IPs))
+	for _, ip := range chosen.IPs {
+		picked[ip] = true
+	}
+	comparisons := make([]*algorithmComparison, 0, len(poolAlgorithms))
+	for _, tag := range PoolAlgorithmTags() {
+		p := policy
+		p.Algorithm = tag
+		r := selector.Select(p)
+		c := &algorithmComparison{
+			Algorithm: tag,
+			Status:    r.Status,
+			Reason:    r.Reason,
+			Count:     len(r.IPs),
+			Minimum:   r.Threshold,
+			Stats:     r.Stats,
 		}
-		bIps, _ := json.Marshal(ips)
-		bStatus, _ := json.Marshal(statusD)
-		fmt.Fprintf(w, "\"status\": %s,\n\"ips\": %s\n}\n", bStatus, bIps)
-	} else {
-		if showStats {
-			doShowStats()
-		}
-		fmt.Fprintf(w, "IP-Gen/1.1:")
-		for k, v := range statusD {
-			var vstr string
-			//fmt.Fprintf(w, " {{%T}}", v)
-			switch v.(type) {
-			case int:
-				vstr = strconv.Itoa(v.(int))
-			case []string:
-				vstr = strings.Join(v.([]string), ",")
-			default:
-				vstr = fmt.Sprintf("%s", v)
+		for _, ip := range r.IPs {
+			if picked[ip] {
+				c.Agree++
 			}
-			fmt.Fprintf(w, " %s=%s", k, vstr)
-		}
-		fmt.Fprintf(w, "\n")
-		for _, ip := range ips {
-			fmt.Fprintf(w, "%s\n", ip)
 		}
-		fmt.Fprintf(w, ".\n")
+		comparisons = append(comparisons, c)
 	}
-
+	return comparisons
 }
 
 func apiIpValidStatsPage(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,253 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// formList gathers a comma-separated parameter which may also be repeated.
+func formList(req *http.Request, key string) []string {
+	var result []string
+	for _, value := range req.Form[key] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// apiWhatIfPage: remove=host1,host2 add=hostA:hostB,hostC:hostD
+func apiWhatIfPage(w http.ResponseWriter, req *http.Request) {
+	var err error
+	if err = req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+
+	var scenario MeshScenario
+	removed := make(map[string]bool)
+	for _, name := range formList(req, "remove") {
+		canon, ok := persisted.Graph.Canonical(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown host %q in 'remove'", name), http.StatusBadRequest)
+			return
+		}
+		canon = strings.ToLower(canon)
+		removed[canon] = true
+		scenario.Remove = append(scenario.Remove, canon)
+	}
+	for _, pair := range formList(req, "add") {
+		ends := strings.SplitN(pair, ":", 2)
+		if len(ends) != 2 || ends[0] == "" || ends[1] == "" {
+			http.Error(w, fmt.Sprintf("Bad link %q in 'add', want host1:host2", pair), http.StatusBadRequest)
+			return
+		}
+		var link [2]string
+		for i, end := range ends {
+			canon, ok := persisted.Graph.Canonical(end)
+			if !ok {
+				http.Error(w, fmt.Sprintf("Unknown host %q in 'add'", end), http.StatusBadRequest)
+				return
+			}
+			canon = strings.ToLower(canon)
+			if removed[canon] {
+				http.Error(w, fmt.Sprintf("Link %q in 'add' is to %q, which is in 'remove'", pair, canon), http.StatusBadRequest)
+				return
+			}
+			link[i] = canon
+		}
+		scenario.Add = append(scenario.Add, link)
+	}
+
+	writeJsonResponse(w, req, SimulateMesh(persisted, scenario))
+}
+
+// apiPeerSuggestPage: host=NAME, or country=XX for a new server; limit=N
+func apiPeerSuggestPage(w http.ResponseWriter, req *http.Request) {
+	var err error
+	if err = req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+
+	request := PeerSuggestionRequest{
+		Host:    req.Form.Get("host"),
+		Country: req.Form.Get("country"),
+		Limit:   10,
+	}
+	if l := req.Form.Get("limit"); l != "" {
+		request.Limit, err = strconv.Atoi(l)
+		if err != nil || request.Limit < 0 {
+			http.Error(w, fmt.Sprintf("Bad 'limit' parameter %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	suggestions, err := SuggestPeers(persisted, request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJsonResponse(w, req, map[string]interface{}{
+		"host":        request.Host,
+		"country":     request.Country,
+		"suggestions": suggestions,
+	})
+}
+
+func apiConsistencyPage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	report := GetCurrentConsistency()
+	if report == nil {
+		http.Error(w, "No consistency check has completed; are there -consistency-keys?", http.StatusServiceUnavailable)
+		return
+	}
+	if host := req.Form.Get("host"); host != "" {
+		for _, server := range report.Servers {
+			if strings.EqualFold(server.Hostname, host) {
+				writeJsonResponse(w, req, server)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("Unknown host %q", host), http.StatusNotFound)
+		return
+	}
+	writeJsonResponse(w, req, report)
+}
+
+func apiReconAuditPage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+	if host := req.Form.Get("host"); host != "" {
+		canon, ok := persisted.Graph.Canonical(host)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown host %q", host), http.StatusNotFound)
+			return
+		}
+		issues := peeringIssuesOf(persisted, canon)
+		if issues == nil {
+			issues = []*PeeringIssue{}
+		}
+		writeJsonResponse(w, req, issues)
+		return
+	}
+	writeJsonResponse(w, req, AuditReconCompatibility(persisted))
+}
+
+func apiKeyLagPage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+	report := GenerateKeyLagReport(persisted)
+	if _, ok := req.Form["stalled"]; ok {
+		stalled := make([]*KeyLagEntry, 0, len(report.Servers))
+		for _, entry := range report.Servers {
+			if entry.SyncStalled {
+				stalled = append(stalled, entry)
+			}
+		}
+		report.Servers = stalled
+	}
+	writeJsonResponse(w, req, report)
+}
+
+func apiAnomaliesPage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+	minimum := SeverityInfo
+	if s := req.Form.Get("severity"); s != "" {
+		minimum = AnomalySeverity(s)
+		if _, ok := severityRank[minimum]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown severity %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	host := req.Form.Get("host")
+	writeJsonResponse(w, req, map[string]interface{}{
+		"mesh_anomaly": persisted.MeshAnomaly,
+		"current":      filterAnomalies(persisted.Anomalies, host, minimum),
+		"recent":       RecentAnomalies(host, minimum),
+	})
+}
+
+func apiUpdateRatePage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form information", http.StatusBadRequest)
+		return
+	}
+	persisted := GetCurrentPersisted()
+	if persisted == nil || persisted.UpdateRate == nil {
+		http.Error(w, "Still awaiting data collection", http.StatusServiceUnavailable)
+		return
+	}
+	if host := req.Form.Get("host"); host != "" {
+		canon, ok := persisted.Graph.Canonical(host)
+		node := persisted.HostMap[canon]
+		if !ok || node == nil {
+			http.Error(w, fmt.Sprintf("Unknown host %q", host), http.StatusNotFound)
+			return
+		}
+		writeJsonResponse(w, req, map[string]interface{}{
+			"hostname":   canon,
+			"daily":      node.DailyHistogram,
+			"hourly":     node.HourlyHistogram,
+			"no_updates": node.NoUpdates,
+		})
+		return
+	}
+	writeJsonResponse(w, req, persisted.UpdateRate)
+}
@@ -0,0 +1,139 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// A server with several addresses is only fetched once by name, which says
+// nothing about whether each address works; broken IPv6 on dual-stack boxes
+// is common.  So once the spidering is done, we fetch the stats again from
+// every address separately.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const IPProbeOK = "ok"
+
+type IPProbe struct {
+	Status   string // IPProbeOK, or what went wrong
+	Latency  time.Duration
+	Keycount int
+}
+
+func (probe *IPProbe) OK() bool {
+	return probe != nil && probe.Status == IPProbeOK
+}
+
+func (probe *IPProbe) String() string {
+	if !probe.OK() {
+		return probe.Status
+	}
+	return fmt.Sprintf("ok, %d keys in %s", probe.Keycount, probe.Latency.Round(time.Millisecond))
+}
+
+// analyzeRecovering runs Analyze, turning a panic into an error.
+func (sn *SksNode) analyzeRecovering() (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("analyze panic: %v", x)
+			sn.analyzeError = err
+		}
+	}()
+	sn.Analyze()
+	return nil
+}
+
+func probeIP(hostname string, port int, ip string) *IPProbe {
+	node := &SksNode{Hostname: hostname, Port: port}
+	start := time.Now()
+	err := node.FetchFrom([]string{ip})
+	probe := &IPProbe{Latency: time.Since(start)}
+	if err != nil {
+		probe.Status = err.Error()
+		return probe
+	}
+	defer node.Minimize()
+	node.analyzeRecovering()
+	if node.analyzeError != nil {
+		probe.Status = node.analyzeError.Error()
+		return probe
+	}
+	probe.Status = IPProbeOK
+	probe.Keycount = node.Keycount
+	return probe
+}
+
+// ProbeHostIPs fills in IPStatus for every working host in the map.
+func ProbeHostIPs(hostMap HostMap) {
+	type probeResult struct {
+		node  *SksNode
+		ip    string
+		probe *IPProbe
+	}
+	results := make(chan *probeResult, QUEUE_DEPTH)
+	limit := make(chan bool, *flIPProbeParallel)
+	var probing sync.WaitGroup
+
+	go func() {
+		for _, node := range hostMap {
+			if node.AnalyzeError != "" {
+				continue
+			}
+			for _, ip := range node.IpList {
+				probing.Add(1)
+				limit <- true
+				go func(node *SksNode, ip string) {
+					defer func() { <-limit; probing.Done() }()
+					results <- &probeResult{node, ip, probeIP(node.Hostname, node.Port, ip)}
+				}(node, ip)
+			}
+		}
+		probing.Wait()
+		close(results)
+	}()
+
+	count, failed := 0, 0
+	for r := range results {
+		if r.node.IPStatus == nil {
+			r.node.IPStatus = make(map[string]*IPProbe, len(r.node.IpList))
+		}
+		r.node.IPStatus[r.ip] = r.probe
+		count++
+		if !r.probe.OK() {
+			failed++
+			Log.Printf("Probe of \"%s\" at [%s] failed: %s", r.node.Hostname, r.ip, r.probe.Status)
+		}
+	}
+	Log.Printf("Probed %d addresses individually, %d failed", count, failed)
+}
+
+// AnsweringIPs are the host's addresses which answered their probe; if the
+// host was never probed (such as when loaded from an old JSON dump), that's
+// all of them.
+func (sn *SksNode) AnsweringIPs() []string {
+	if sn.IPStatus == nil {
+		return sn.IpList
+	}
+	ips := make([]string, 0, len(sn.IpList))
+	for _, ip := range sn.IpList {
+		if sn.IPStatus[ip].OK() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,41 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnsweringIPs(t *testing.T) {
+	node := &SksNode{Hostname: "keys.example.org", IpList: []string{"192.0.2.1", "2001:db8::1"}}
+	if !reflect.DeepEqual(node.AnsweringIPs(), node.IpList) {
+		t.Fatalf("Unprobed node should answer on all IPs, got %v", node.AnsweringIPs())
+	}
+
+	// loopback is refused before any connection is attempted
+	node.IPStatus = map[string]*IPProbe{
+		"192.0.2.1":   {Status: IPProbeOK, Keycount: 5000000},
+		"2001:db8::1": probeIP(node.Hostname, 11371, "::1"),
+	}
+	if node.IPStatus["2001:db8::1"].OK() {
+		t.Fatal("Probe of a disallowed address succeeded")
+	}
+	if answering := node.AnsweringIPs(); !reflect.DeepEqual(answering, []string{"192.0.2.1"}) {
+		t.Fatalf("Expected only the IPv4 address to answer, got %v", answering)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// The stats page says nothing about whether key lookups work.  So we fetch
+// some canary keys from every address of every server, with op=get and with
+// op=index, and check that we got back the key we asked for.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LookupOpGet   = "get"
+	LookupOpIndex = "index"
+)
+
+type LookupProbe struct {
+	Key     string
+	Op      string
+	IP      string
+	OK      bool
+	Status  string // what went wrong, or a summary of what came back
+	Latency time.Duration
+}
+
+var fingerprintRe = regexp.MustCompile(`^([0-9A-F]{40}|[0-9A-F]{64})$`)
+
+// normalizeFingerprint accepts the usual ways of writing a fingerprint.
+func normalizeFingerprint(text string) (string, error) {
+	fpr := strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	fpr = strings.TrimPrefix(fpr, "0X")
+	if !fingerprintRe.MatchString(fpr) {
+		return "", fmt.Errorf("bad key fingerprint %q, want 40 or 64 hex digits", text)
+	}
+	return fpr, nil
+}
+
+func parseCanaryKeys(spec string) ([]string, error) {
+	var keys []string
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		fpr, err := normalizeFingerprint(item)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fpr)
+	}
+	return keys, nil
+}
+
+// hkpLookup fetches a lookup result from one address of the server.
+func hkpLookup(hostname string, port int, ip, op, fpr string) ([]byte, error) {
+	query := url.Values{"op": {op}, "options": {"mr"}, "search": {"0x" + fpr}}
+	uri := fmt.Sprintf("http://%s:%d/pks/lookup?%s", hostname, port, query.Encode())
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *flHttpFetchTimeout+2*time.Second)
+	defer cancel()
+	req = req.WithContext(withVettedIPs(ctx, hostname, []string{ip}))
+	req.Header.Set("User-Agent", "sks_peers/0.2 (SKS mesh spidering)")
+	cl := *getHTTPClient()
+	cl.CheckRedirect = checkSameHostRedirect
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return readBoundedBody(resp.Body, *flHttpMaxBody)
+}
+
+// keyIDOf is the long key ID for a fingerprint: the low 64 bits of a v4
+// fingerprint, but the high 64 bits of a v6 one.
+func keyIDOf(fpr string) string {
+	if len(fpr) == 64 {
+		return fpr[:16]
+	}
+	return fpr[len(fpr)-16:]
+}
+
+// checkIndexMR looks for the key in a machine-readable index; the pub line
+// may give the fingerprint or just the long key ID.
+func checkIndexMR(body []byte, fpr string) (string, error) {
+	keys := 0
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if fields[0] != "pub" || len(fields) < 2 {
+			continue
+		}
+		keys++
+		id := strings.ToUpper(fields[1])
+		if id == fpr || id == keyIDOf(fpr) {
+			return fmt.Sprintf("found among %d keys", keys), nil
+		}
+	}
+	return "", fmt.Errorf("key not in index of %d keys", keys)
+}
+
+// checkArmoredKey wants a well-formed armoured block whose first primary
+// key is the one we asked for.
+func checkArmoredKey(body []byte, fpr string) (string, error) {
+	packets, err := dearmor(string(body))
+	if err != nil {
+		return "", err
+	}
+	got, err := primaryFingerprint(packets)
+	if err != nil {
+		return "", err
+	}
+	if got != fpr {
+		return "", fmt.Errorf("got key %s instead", got)
+	}
+	return fmt.Sprintf("key ok, %d bytes", len(packets)), nil
+}
+
+func probeLookup(node *SksNode, ip, op, fpr string) *LookupProbe {
+	probe := &LookupProbe{Key: fpr, Op: op, IP: ip}
+	start := time.Now()
+	body, err := hkpLookup(node.Hostname, node.Port, ip, op, fpr)
+	probe.Latency = time.Since(start)
+	if err == nil {
+		if op == LookupOpGet {
+			probe.Status, err = checkArmoredKey(body, fpr)
+		} else {
+			probe.Status, err = checkIndexMR(body, fpr)
+		}
+	}
+	if err != nil {
+		probe.Status = err.Error()
+		return probe
+	}
+	probe.OK = true
+	return probe
+}
+
+// LookupOK is whether every lookup made to that address worked; false if
+// there were none.
+func (sn *SksNode) LookupOK(ip string) bool {
+	seen := false
+	for _, probe := range sn.Lookups {
+		if probe.IP != ip {
+			continue
+		}
+		if !probe.OK {
+			return false
+		}
+		seen = true
+	}
+	return seen
+}
+
+// ProbeKeyLookups fills in Lookups for every working host, if we have any
+// canary keys.
+func ProbeKeyLookups(hostMap HostMap) {
+	canaries, _ := parseCanaryKeys(*flCanaryKeys)
+	if len(canaries) == 0 {
+		return
+	}
+	limit := make(chan bool, *flIPProbeParallel)
+	var probing sync.WaitGroup
+	var lock sync.Mutex
+	count, failed := 0, 0
+	for _, node := range hostMap {
+		if node.AnalyzeError != "" {
+			continue
+		}
+		node.Lookups = nil
+		for _, ip := range node.AnsweringIPs() {
+			for _, fpr := range canaries {
+				for _, op := range []string{LookupOpGet, LookupOpIndex} {
+					probing.Add(1)
+					limit <- true
+					go func(node *SksNode, ip, op, fpr string) {
+						defer func() { <-limit; probing.Done() }()
+						probe := probeLookup(node, ip, op, fpr)
+						lock.Lock()
+						defer lock.Unlock()
+						node.Lookups = append(node.Lookups, probe)
+						count++
+						if !probe.OK {
+							failed++
+							Log.Printf("Lookup op=%s of %s from \"%s\" [%s] failed: %s", op, fpr, node.Hostname, ip, probe.Status)
+						}
+					}(node, ip, op, fpr)
+				}
+			}
+		}
+	}
+	probing.Wait()
+	Log.Printf("Made %d canary key lookups, %d failed", count, failed)
+}
+
+// lookupSummary is for display: how many lookups worked, and the failures.
+func (sn *SksNode) lookupSummary() (string, []string) {
+	if len(sn.Lookups) == 0 {
+		return "not probed", nil
+	}
+	ok := 0
+	var failures []string
+	var total time.Duration
+	for _, probe := range sn.Lookups {
+		total += probe.Latency
+		if probe.OK {
+			ok++
+		} else {
+			failures = append(failures, fmt.Sprintf("op=%s %s [%s]: %s", probe.Op, probe.Key, probe.IP, probe.Status))
+		}
+	}
+	mean := total / time.Duration(len(sn.Lookups))
+	return strconv.Itoa(ok) + " of " + strconv.Itoa(len(sn.Lookups)) + " ok, mean " + mean.Round(time.Millisecond).String(), failures
+}
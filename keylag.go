@@ -0,0 +1,151 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// A server whose recon has quietly stopped still answers stats requests; it
+// just never gains keys.  We remember the last few scans' keycounts, so that
+// a server frozen while the mesh grew can be called out.
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type keycountSample struct {
+	When      time.Time
+	Keycount  int
+	Reference int
+}
+
+// keycountHistoryMin is enough scans to see a keycount flapping.
+const keycountHistoryMin = 5
+
+var (
+	keycountHistory     = make(map[string][]keycountSample)
+	keycountHistoryLock sync.Mutex
+)
+
+// frozenScans is how many of the most recent samples, counting back from the
+// latest, have the same keycount as the latest.
+func frozenScans(samples []keycountSample) int {
+	if len(samples) == 0 {
+		return 0
+	}
+	latest := samples[len(samples)-1].Keycount
+	n := 0
+	for i := len(samples) - 1; i >= 0 && samples[i].Keycount == latest; i-- {
+		n++
+	}
+	return n
+}
+
+// syncStalled holds when the keycount has not moved across the last `scans`
+// samples while the mesh reference grew by at least `growth`.
+func syncStalled(samples []keycountSample, scans, growth int) bool {
+	if scans < 2 || len(samples) < scans || frozenScans(samples) < scans {
+		return false
+	}
+	first := samples[len(samples)-scans]
+	last := samples[len(samples)-1]
+	return last.Reference-first.Reference >= growth
+}
+
+// RecordKeycountHistory notes this scan's keycounts and sets KeysBehind,
+// FrozenScans and SyncStalled on each node.  Servers which drop out of the
+// mesh are forgotten.
+func RecordKeycountHistory(persisted *PersistedHostInfo) {
+	reference := MeshKeycountReference(persisted.HostMap)
+	persisted.KeycountReference = reference
+	when := persisted.Timestamp
+	if when.IsZero() {
+		when = time.Now()
+	}
+
+	keep := *flStallScans
+	if keep < keycountHistoryMin {
+		keep = keycountHistoryMin
+	}
+
+	keycountHistoryLock.Lock()
+	defer keycountHistoryLock.Unlock()
+	for hostname := range keycountHistory {
+		if _, ok := persisted.HostMap[hostname]; !ok {
+			delete(keycountHistory, hostname)
+		}
+	}
+	for hostname, node := range persisted.HostMap {
+		if !NodeHealthy(node) {
+			node.KeysBehind, node.FrozenScans, node.SyncStalled = 0, 0, false
+			continue
+		}
+		node.KeysBehind = KeysBehind(node, reference)
+		samples := append(keycountHistory[hostname], keycountSample{
+			When:      when,
+			Keycount:  node.Keycount,
+			Reference: reference,
+		})
+		if len(samples) > keep {
+			samples = samples[len(samples)-keep:]
+		}
+		keycountHistory[hostname] = samples
+		node.FrozenScans = frozenScans(samples)
+		node.SyncStalled = syncStalled(samples, *flStallScans, *flStallGrowth)
+	}
+}
+
+type KeyLagEntry struct {
+	Hostname    string `json:"hostname"`
+	Keycount    int    `json:"keycount"`
+	KeysBehind  int    `json:"keys_behind"`
+	Lagging     bool   `json:"lagging"`
+	FrozenScans int    `json:"frozen_scans"`
+	SyncStalled bool   `json:"sync_stalled"`
+}
+
+type KeyLagReport struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Reference int            `json:"reference"`
+	Servers   []*KeyLagEntry `json:"servers"`
+}
+
+// GenerateKeyLagReport lists the healthy servers, furthest behind first.
+func GenerateKeyLagReport(persisted *PersistedHostInfo) *KeyLagReport {
+	report := &KeyLagReport{
+		Timestamp: persisted.Timestamp,
+		Reference: persisted.KeycountReference,
+		Servers:   make([]*KeyLagEntry, 0, len(persisted.HostMap)),
+	}
+	for _, hostname := range persisted.Sorted {
+		node := persisted.HostMap[hostname]
+		if !NodeHealthy(node) {
+			continue
+		}
+		report.Servers = append(report.Servers, &KeyLagEntry{
+			Hostname:    hostname,
+			Keycount:    node.Keycount,
+			KeysBehind:  node.KeysBehind,
+			Lagging:     node.KeysBehind > *flKeysLagWarn,
+			FrozenScans: node.FrozenScans,
+			SyncStalled: node.SyncStalled,
+		})
+	}
+	sort.SliceStable(report.Servers, func(i, j int) bool {
+		return report.Servers[i].KeysBehind > report.Servers[j].KeysBehind
+	})
+	return report
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncStalled(t *testing.T) {
+	samples := []keycountSample{
+		{Keycount: 900, Reference: 1000},
+		{Keycount: 950, Reference: 1050},
+		{Keycount: 950, Reference: 1100},
+		{Keycount: 950, Reference: 1200},
+	}
+	if n := frozenScans(samples); n != 3 {
+		t.Errorf("frozenScans: expected 3, got %d", n)
+	}
+	if !syncStalled(samples, 3, 100) {
+		t.Error("frozen for 3 scans while mesh grew 150: expected stalled")
+	}
+	if syncStalled(samples, 3, 200) {
+		t.Error("mesh grew only 150 of the 200 needed: expected not stalled")
+	}
+	if syncStalled(samples, 4, 1) {
+		t.Error("keycount moved within the last 4 scans: expected not stalled")
+	}
+	if syncStalled(samples[:2], 3, 1) {
+		t.Error("too few scans to judge: expected not stalled")
+	}
+}
+
+func TestRecordKeycountHistory(t *testing.T) {
+	keycountHistoryLock.Lock()
+	keycountHistory = make(map[string][]keycountSample)
+	keycountHistoryLock.Unlock()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	growing := []int{5000000, 5000500, 5001000}
+	for scan := 0; scan < 3; scan++ {
+		hostMap := HostMap{
+			"a.example.org":     &SksNode{Hostname: "a.example.org", Keycount: growing[scan]},
+			"b.example.org":     &SksNode{Hostname: "b.example.org", Keycount: growing[scan] + 10},
+			"c.example.org":     &SksNode{Hostname: "c.example.org", Keycount: growing[scan] - 10},
+			"d.example.org":     &SksNode{Hostname: "d.example.org", Keycount: growing[scan] + 20},
+			"stuck.example.org": &SksNode{Hostname: "stuck.example.org", Keycount: 4990000},
+			"broken.example.org": &SksNode{Hostname: "broken.example.org",
+				AnalyzeError: "no stats"},
+		}
+		persisted := &PersistedHostInfo{
+			HostMap:   hostMap,
+			Sorted:    GenerateHostlistSorted(hostMap),
+			Timestamp: start.Add(time.Duration(scan) * 8 * time.Hour),
+		}
+		RecordKeycountHistory(persisted)
+
+		if persisted.KeycountReference != growing[scan] {
+			t.Errorf("scan %d: reference %d, expected %d", scan, persisted.KeycountReference, growing[scan])
+		}
+		stuck := hostMap["stuck.example.org"]
+		if stuck.KeysBehind != growing[scan]-4990000 {
+			t.Errorf("scan %d: stuck is %d behind, expected %d", scan, stuck.KeysBehind, growing[scan]-4990000)
+		}
+		if stuck.FrozenScans != scan+1 {
+			t.Errorf("scan %d: stuck frozen for %d scans, expected %d", scan, stuck.FrozenScans, scan+1)
+		}
+		if want := scan == 2; stuck.SyncStalled != want {
+			t.Errorf("scan %d: stuck stalled=%v, expected %v", scan, stuck.SyncStalled, want)
+		}
+		if hostMap["a.example.org"].SyncStalled || hostMap["a.example.org"].KeysBehind != 0 {
+			t.Errorf("scan %d: healthy growing server flagged", scan)
+		}
+
+		report := GenerateKeyLagReport(persisted)
+		if len(report.Servers) != 5 || report.Servers[0].Hostname != "stuck.example.org" {
+			t.Errorf("scan %d: report should list 5 healthy servers, stuck first: %+v", scan, report.Servers)
+		}
+	}
+
+	keycountHistoryLock.Lock()
+	defer keycountHistoryLock.Unlock()
+	if _, ok := keycountHistory["broken.example.org"]; ok {
+		t.Error("unhealthy server should not gain history")
+	}
+}
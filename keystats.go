@@ -0,0 +1,113 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Mesh-wide reference values, against which individual servers are judged.
+
+import (
+	"sort"
+	"strings"
+)
+
+// NodeHealthy is true for a server which gave us a clean stats page with a
+// plausible keycount.
+func NodeHealthy(node *SksNode) bool {
+	return node != nil && node.AnalyzeError == "" && node.Keycount > 1
+}
+
+func medianInt(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// MeshKeycountReference is the median keycount of the healthy servers; a
+// handful of broken or stalled servers can't drag it around.
+func MeshKeycountReference(hostmap HostMap) int {
+	counts := make([]int, 0, len(hostmap))
+	for _, node := range hostmap {
+		if NodeHealthy(node) {
+			counts = append(counts, node.Keycount)
+		}
+	}
+	return medianInt(counts)
+}
+
+// KeysBehind is how far short of the reference a server is; servers ahead of
+// the reference are not behind.
+func KeysBehind(node *SksNode, reference int) int {
+	if node.Keycount >= reference {
+		return 0
+	}
+	return reference - node.Keycount
+}
+
+// MeshLatestVersions finds the newest release seen for each software package,
+// keyed by lower-cased software name.  The "+" development marker is dropped,
+// so that running the release proper is not counted as outdated.
+func MeshLatestVersions(hostmap HostMap) map[string]*SksVersion {
+	latest := make(map[string]*SksVersion)
+	for _, node := range hostmap {
+		if !NodeHealthy(node) {
+			continue
+		}
+		v := NewSksVersion(node.Version)
+		if v == nil {
+			continue
+		}
+		v.Tag = ""
+		software := strings.ToLower(node.SoftwareName())
+		if have, ok := latest[software]; !ok || !have.IsAtLeast(v) {
+			latest[software] = v
+		}
+	}
+	return latest
+}
+
+// NodeOutdated reports whether the node runs an older release than the newest
+// seen in the mesh for the same software.  Unparseable versions are not judged:
+// newest is nil when there is nothing to compare.
+func NodeOutdated(node *SksNode, latest map[string]*SksVersion) (outdated bool, newest *SksVersion) {
+	v := NewSksVersion(node.Version)
+	if v == nil {
+		return false, nil
+	}
+	newest = latest[strings.ToLower(node.SoftwareName())]
+	if newest == nil {
+		return false, nil
+	}
+	return !v.IsAtLeast(newest), newest
+}
+
+// medianMutualPeers is the typical number of mutual peers for a healthy server.
+func medianMutualPeers(persisted *PersistedHostInfo) int {
+	counts := make([]int, 0, len(persisted.HostMap))
+	for hostname, node := range persisted.HostMap {
+		if NodeHealthy(node) {
+			counts = append(counts, len(persisted.Graph.MutualPeersOf(strings.ToLower(hostname))))
+		}
+	}
+	return medianInt(counts)
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"testing"
+)
+
+func TestMedianInt(t *testing.T) {
+	for _, tc := range []struct {
+		values []int
+		want   int
+	}{
+		{nil, 0},
+		{[]int{7}, 7},
+		{[]int{3, 1, 2}, 2},
+		{[]int{4, 1, 3, 2}, 2},
+		{[]int{10, 20}, 15},
+		{[]int{5, 5, 100, 5, 5}, 5},
+	} {
+		if got := medianInt(tc.values); got != tc.want {
+			t.Errorf("medianInt(%v) = %d, expected %d", tc.values, got, tc.want)
+		}
+	}
+
+	values := []int{3, 1, 2}
+	medianInt(values)
+	if values[0] != 3 || values[1] != 1 {
+		t.Errorf("medianInt sorted its argument in place: %v", values)
+	}
+}
+
+func TestKeysBehind(t *testing.T) {
+	for _, tc := range []struct {
+		keycount  int
+		reference int
+		want      int
+	}{
+		{3000000, 3000000, 0},
+		{3000100, 3000000, 0},
+		{2999000, 3000000, 1000},
+		{0, 3000000, 3000000},
+	} {
+		if got := KeysBehind(&SksNode{Keycount: tc.keycount}, tc.reference); got != tc.want {
+			t.Errorf("KeysBehind(%d, %d) = %d, expected %d", tc.keycount, tc.reference, got, tc.want)
+		}
+	}
+}
+
+func TestNodeOutdated(t *testing.T) {
+	hostmap := HostMap{
+		"a": {Version: "1.1.3", Keycount: 3000000},
+		"b": {Version: "1.1.4+", Keycount: 3000000},
+		"c": {Version: "1.1.6", Keycount: 3000000, AnalyzeError: "broken"},
+		"d": {Version: "1.0.2", Software: "Hockeypuck", Keycount: 3000000},
+	}
+	latest := MeshLatestVersions(hostmap)
+
+	for _, tc := range []struct {
+		node     *SksNode
+		outdated bool
+		newest   string
+	}{
+		{&SksNode{Version: "1.1.3"}, true, "1.1.4"},
+		{&SksNode{Version: "1.1.4"}, false, "1.1.4"},
+		{&SksNode{Version: "1.1.4+"}, false, "1.1.4"},
+		{&SksNode{Version: "1.1.5"}, false, "1.1.4"},
+		{&SksNode{Version: "1.0.2", Software: "hockeypuck"}, false, "1.0.2"},
+		{&SksNode{Version: "0.9", Software: "Hockeypuck"}, false, ""},
+		{&SksNode{Version: "2.0.0", Software: "Other"}, false, ""},
+		{&SksNode{Version: "garbage"}, false, ""},
+		{&SksNode{Version: ""}, false, ""},
+	} {
+		outdated, newest := NodeOutdated(tc.node, latest)
+		newestString := ""
+		if newest != nil {
+			newestString = newest.String()
+		}
+		if outdated != tc.outdated || newestString != tc.newest {
+			t.Errorf("%s %s: outdated=%v newest=%q, expected %v %q",
+				tc.node.SoftwareName(), tc.node.Version, outdated, newestString, tc.outdated, tc.newest)
+		}
+	}
+}
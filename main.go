@@ -31,6 +31,21 @@ import (
 
 var (
 	flSpiderStartHost    = flag.String("spider-start-host", "pgpkeys.eu", "Host to query to start things rolling")
+	flSpiderSeedHosts    = flag.String("spider-seed-hosts", "", "More hosts to start spidering from, comma-separated; host=N to seed at distance N")
+	flSeedFromMembership = flag.Bool("spider-seed-membership", false, "Also start spidering from every host in the membership file")
+	flSeedMemberDistance = flag.Int("spider-membership-distance", 0, "Distance given to hosts seeded from the membership file")
+	flSpiderMaxDepth     = flag.Int("spider-max-depth", -1, "Maximum distance from the seeds to spider to; negative for no limit")
+	flSpiderMaxHosts     = flag.Int("spider-max-hosts", 0, "Maximum number of hosts to look up per spidering run; 0 for no limit")
+	flSpiderAllowDomain  = flag.String("spider-allow-domains", "", "Only spider hosts in these domains, comma-separated; ~regexp matches hostnames")
+	flSpiderDenyDomain   = flag.String("spider-deny-domains", "", "Never spider hosts in these domains, comma-separated; ~regexp matches hostnames")
+	flExclusionPolicy    = flag.String("exclusion-policy-file", "", "File of hosts, globs and CIDR blocks never to spider")
+	flExclusionRecheck   = flag.Duration("exclusion-recheck", time.Minute, "How often to check the exclusion policy file for changes")
+	flDisallowedIPs      = flag.String("disallowed-ips", "", "More CIDR blocks never to spider, comma-separated, beyond the IANA special-purpose ones")
+	flIPProbeParallel    = flag.Int("ip-probe-parallel", 16, "How many addresses to probe individually at once, after spidering")
+	flReconProbe         = flag.Bool("recon-probe", true, "Connect to each server's recon port and exchange config, after spidering")
+	flReconProbeTimeout  = flag.Duration("recon-probe-timeout", 15*time.Second, "Timeout for each recon port probe")
+	flCanaryKeys         = flag.String("canary-keys", "", "Fingerprints of keys to look up from every server, comma-separated")
+	flConsistencyKeys    = flag.String("consistency-keys", "", "Fingerprints of keys to compare across servers after each scan; default the -canary-keys")
 	flListen             = flag.String("listen", "localhost:8001", "port to listen on with web-server")
 	flMaintEmail         = flag.String("maint-email", "webmaster@spodhuis.org", "Email address of local maintainer")
 	flMyStylesheet       = flag.String("stylesheet", "/styles/sks-peers.css", "CSS Style sheet to use")
@@ -40,6 +55,13 @@ var (
 	flCountriesZone      = flag.String("countries-zone", "zz.countries.nerd.dk.", "DNS zone for determining IP locations")
 	flKeysSanityMin      = flag.Int("keys-sanity-min", 4500000, "Minimum number of keys that's sane, or we're broken")
 	flKeysDailyJitter    = flag.Int("keys-daily-jitter", 800, "Max daily jitter in key count")
+	flIPValidBucket      = flag.Int("ip-valid-bucket-size", 0, "Keycount bucket size for the default ip-valid algorithm; 0 for twice -keys-daily-jitter")
+	flKeysLagWarn        = flag.Int("keys-lag-warn", 5000, "Keys behind the mesh before a server counts as lagging")
+	flStallScans         = flag.Int("sync-stall-scans", 3, "Scans a server's keycount must stay frozen for before it counts as stalled")
+	flStallGrowth        = flag.Int("sync-stall-growth", 100, "Mesh keycount growth across those scans before a frozen server counts as stalled")
+	flAnomalyDropPct     = flag.Float64("anomaly-drop-percent", 1, "Percentage of its keys a server must lose between scans to raise an event")
+	flAnomalyJumpPct     = flag.Float64("anomaly-jump-percent", 2, "Percentage of keys a server must gain between scans, beyond the mesh, to raise an event")
+	flMeshDropPct        = flag.Float64("mesh-drop-percent", 5, "Percentage drop of the mesh keycount between scans before ip-valid refuses to answer")
 	flScanIntervalSecs   = flag.Int("scan-interval", 3600*8, "How often to trigger a scan")
 	flScanIntervalJitter = flag.Int("scan-interval-jitter", 120, "Jitter in scan interval")
 	flLogFile            = flag.String("log-file", "sksdaemon.log", "Where to write logfiles")
@@ -49,6 +71,7 @@ var (
 	flJsonPersistPath    = flag.String("json-persist", "", "File to load at startup if exists, and write to at SIGUSR1")
 	flStartedFlagfile    = flag.String("started-file", "", "Create this file after started and running")
 	flHttpFetchTimeout   = flag.Duration("http-fetch-timeout", 30*time.Second, "Timeout for HTTP fetch from SKS servers")
+	flHttpMaxBody        = flag.Int64("http-max-body", 4<<20, "Largest stats page we'll read from an SKS server, in bytes")
 )
 
 var VersionString string
@@ -59,13 +82,6 @@ var serverHeadersNative = map[string]bool{
 }
 var defaultSoftware = "SKS"
 
-// People put dumb things in their membership files
-var blacklistedQueryHosts = []string{
-	"localhost",
-	"127.0.0.1",
-	"::1",
-}
-
 var Log *log.Logger
 
 func setupLogging() {
@@ -88,7 +104,15 @@ type PersistedHostInfo struct {
 	Sorted       []string
 	DepthSorted  []string
 	Graph        *HostGraph
+	Seeds        []*SeedStatus
+	Skipped      []*SkippedHost
 	Timestamp    time.Time
+	// Median keycount of the healthy servers at this scan
+	KeycountReference int
+	Anomalies         []*AnomalyEvent
+	// Why this scan's keycounts can't be trusted; empty if they can
+	MeshAnomaly string
+	UpdateRate  *UpdateRateReport
 }
 
 var (
@@ -122,6 +146,9 @@ func GetCurrentHostlist() []string {
 
 func SetCurrentPersisted(p *PersistedHostInfo) {
 	p.Timestamp = time.Now()
+	RecordKeycountHistory(p)
+	DetectKeycountAnomalies(p)
+	p.UpdateRate = GenerateUpdateRate(p.HostMap, p.Timestamp)
 	p.LogInformation()
 	currentHostMapLock.Lock()
 	defer currentHostMapLock.Unlock()
@@ -133,6 +160,7 @@ func normaliseMeshAndSet(spider *Spider, dumpJson bool) {
 		persisted := GeneratePersistedInformation(s)
 		SetCurrentPersisted(persisted)
 		persisted.UpdateStatsCounters(spider)
+		go runConsistencyCheck(persisted)
 		runtime.GC()
 		if dumpJson && *flJsonDump != "" {
 			Log.Printf("Saving JSON to \"%s\"", *flJsonDump)
@@ -171,8 +199,7 @@ func respiderPeriodically() {
 				}
 				sp.Terminate()
 			}(spider)
-			spider.AddHost(*flSpiderStartHost, 0)
-			spider.Wait()
+			spiderFromSeeds(spider)
 		}()
 		normaliseMeshAndSet(spider, false)
 	}
@@ -213,14 +240,72 @@ func Main() {
 		flag.Parse()
 	}
 
+	if *flExclusionRecheck <= 0 {
+		fmt.Fprintf(os.Stderr, "Bad -exclusion-recheck, must be > 0 [got: %s]\n", *flExclusionRecheck)
+		os.Exit(1)
+	}
+	if *flHttpMaxBody < 1 {
+		fmt.Fprintf(os.Stderr, "Bad -http-max-body, must be >= 1 [got: %d]\n", *flHttpMaxBody)
+		os.Exit(1)
+	}
+	if _, err := parseCanaryKeys(*flCanaryKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -canary-keys: %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := parseCanaryKeys(*flConsistencyKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -consistency-keys: %s\n", err)
+		os.Exit(1)
+	}
+	for _, pct := range []struct {
+		name  string
+		value float64
+	}{
+		{"anomaly-drop-percent", *flAnomalyDropPct},
+		{"anomaly-jump-percent", *flAnomalyJumpPct},
+		{"mesh-drop-percent", *flMeshDropPct},
+	} {
+		if pct.value <= 0 || pct.value > 100 {
+			fmt.Fprintf(os.Stderr, "Bad -%s, must be in (0, 100] [got: %g]\n", pct.name, pct.value)
+			os.Exit(1)
+		}
+	}
+	if *flIPValidBucket < 0 {
+		fmt.Fprintf(os.Stderr, "Bad -ip-valid-bucket-size, must be >= 0 [got: %d]\n", *flIPValidBucket)
+		os.Exit(1)
+	}
+	if *flIPProbeParallel < 1 {
+		fmt.Fprintf(os.Stderr, "Bad -ip-probe-parallel, must be >= 1 [got: %d]\n", *flIPProbeParallel)
+		os.Exit(1)
+	}
 	if *flScanIntervalJitter < 0 {
 		fmt.Fprintf(os.Stderr, "Bad jitter, must be >= 0 [got: %d]\n", *flScanIntervalJitter)
 		os.Exit(1)
 	}
+	if _, err := parseSeedHosts(*flSpiderSeedHosts); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -spider-seed-hosts: %s\n", err)
+		os.Exit(1)
+	}
+	if err := AddDisallowedIPs(*flDisallowedIPs); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -disallowed-ips: %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := NewScopePolicy(*flSpiderMaxDepth, *flSpiderMaxHosts, *flSpiderAllowDomain, *flSpiderDenyDomain); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad spider scope: %s\n", err)
+		os.Exit(1)
+	}
 
 	setupLogging()
 	Log.Printf("started")
 
+	if *flExclusionPolicy != "" {
+		if err := ReloadExclusionPolicy(); err != nil {
+			Log.Fatalf("Unable to load -exclusion-policy-file: %s", err)
+		}
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go watchExclusionPolicy(hupChan)
+	}
+
 	httpServing.Add(1)
 	go startHttpServing()
 
@@ -244,18 +329,19 @@ func Main() {
 		hostnames := GenerateHostlistSorted(hostmap)
 		countryMap := GetFreshCountryForHostmap(hostmap)
 		aliasMap := GetAliasMapForHostmap(hostmap)
-		SetCurrentPersisted(&PersistedHostInfo{
+		persisted := &PersistedHostInfo{
 			HostMap:      hostmap,
 			AliasMap:     aliasMap,
 			IPCountryMap: countryMap,
 			Sorted:       hostnames,
-			DepthSorted:  GenerateDepthSorted(hostmap),
 			Graph:        GenerateGraph(hostnames, hostmap, aliasMap),
-		})
+		}
+		persisted.AssignDistances(seedsFromHostmap(hostmap))
+		persisted.DepthSorted = GenerateDepthSorted(hostmap)
+		SetCurrentPersisted(persisted)
 	} else {
 		spider := StartSpider()
-		spider.AddHost(*flSpiderStartHost, 0)
-		spider.Wait()
+		spiderFromSeeds(spider)
 		spider.Terminate()
 		Log.Printf("Start-up initial spidering complete")
 		normaliseMeshAndSet(spider, true)
@@ -18,21 +18,137 @@ package sks_spider
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-func GetMembershipHosts() ([]string, error) {
-	fh, err := os.Open(*flSksMembershipFile)
-	if err != nil {
-		return nil, err
+// The SKS membership file has one peer per line, "hostname reconport", with
+// anything after a '#' being a comment; operators put contact details there.
+// We keep every line, so that the file can be rewritten without losing any
+// of that, and only re-render the entries which have been changed.
+
+type MembershipEntry struct {
+	Line    int
+	Host    string
+	Port    int // recon port; 0 if not given
+	Comment string
+}
+
+func (me *MembershipEntry) String() string {
+	host := me.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
 	}
-	defer fh.Close()
-	reader := bufio.NewReader(fh)
-	hosts := make([]string, 0, 100)
-	matcher := regexp.MustCompile(`^([A-Za-z0-9]\S+)\s+\d`)
+	s := host
+	if me.Port != 0 {
+		s += " " + strconv.Itoa(me.Port)
+	}
+	if me.Comment != "" {
+		s += " #" + me.Comment
+	}
+	return s
+}
+
+// ReconPort is the port given, or our default.
+func (me *MembershipEntry) ReconPort() int {
+	if me.Port != 0 {
+		return me.Port
+	}
+	return *flSksPortRecon
+}
+
+type MembershipWarning struct {
+	Line    int
+	Text    string
+	Problem string
+}
+
+func (mw *MembershipWarning) Error() string {
+	return fmt.Sprintf("membership line %d: %s: %q", mw.Line, mw.Problem, mw.Text)
+}
+
+type membershipLine struct {
+	raw      string
+	entry    *MembershipEntry
+	original MembershipEntry
+}
+
+type MembershipFile struct {
+	lines          []*membershipLine
+	noFinalNewline bool
+	Warnings       []*MembershipWarning
+	nextLineNumber int
+}
+
+var membershipHostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+
+func (mf *MembershipFile) warn(line int, text, problem string, v ...interface{}) {
+	mf.Warnings = append(mf.Warnings, &MembershipWarning{
+		Line: line, Text: text, Problem: fmt.Sprintf(problem, v...),
+	})
+}
+
+func (mf *MembershipFile) parseLine(number int, raw string) *MembershipEntry {
+	text := strings.TrimRight(raw, "\r")
+	var comment string
+	hasComment := false
+	if i := strings.IndexByte(text, '#'); i >= 0 {
+		comment = text[i+1:]
+		hasComment = true
+		text = text[:i]
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	host := fields[0]
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			mf.warn(number, raw, "unterminated IPv6 literal")
+			return nil
+		}
+		host = host[1 : len(host)-1]
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			mf.warn(number, raw, "bad IPv6 literal")
+			return nil
+		}
+	} else if net.ParseIP(host) == nil && !membershipHostnameRe.MatchString(host) {
+		mf.warn(number, raw, "bad hostname %q", host)
+		return nil
+	}
+
+	entry := &MembershipEntry{Line: number, Host: host}
+	if hasComment {
+		entry.Comment = comment
+	}
+	switch len(fields) {
+	case 1:
+		mf.warn(number, raw, "missing recon port, assuming %d", *flSksPortRecon)
+	default:
+		port, err := strconv.Atoi(fields[1])
+		if err != nil || port < 1 || port > 65535 {
+			mf.warn(number, raw, "bad recon port %q", fields[1])
+			return nil
+		}
+		entry.Port = port
+		if len(fields) > 2 {
+			mf.warn(number, raw, "ignoring trailing text %q", strings.Join(fields[2:], " "))
+		}
+	}
+	return entry
+}
+
+// ParseMembership reads a membership file.  Malformed lines are kept for
+// rewriting but yield no entry, only a warning.
+func ParseMembership(in io.Reader) (*MembershipFile, error) {
+	mf := &MembershipFile{}
+	reader := bufio.NewReader(in)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil && err != io.EOF {
@@ -41,16 +157,105 @@ func GetMembershipHosts() ([]string, error) {
 		if line == "" && err == io.EOF {
 			break
 		}
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		mf.nextLineNumber++
+		raw := strings.TrimSuffix(line, "\n")
+		if raw == line {
+			mf.noFinalNewline = true
+		}
+		ml := &membershipLine{raw: raw}
+		if ml.entry = mf.parseLine(mf.nextLineNumber, raw); ml.entry != nil {
+			ml.original = *ml.entry
+		}
+		mf.lines = append(mf.lines, ml)
+		if err == io.EOF {
+			break
+		}
+	}
+	return mf, nil
+}
+
+func LoadMembershipFile(filename string) (*MembershipFile, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return ParseMembership(fh)
+}
+
+func (mf *MembershipFile) Entries() []*MembershipEntry {
+	entries := make([]*MembershipEntry, 0, len(mf.lines))
+	for _, ml := range mf.lines {
+		if ml.entry != nil {
+			entries = append(entries, ml.entry)
 		}
-		matches := matcher.FindStringSubmatch(line)
-		if matches == nil {
+	}
+	return entries
+}
+
+func (mf *MembershipFile) Hosts() []string {
+	entries := mf.Entries()
+	hosts := make([]string, len(entries))
+	for i := range entries {
+		hosts[i] = entries[i].Host
+	}
+	return hosts
+}
+
+// Add appends a new entry to the end of the file.
+func (mf *MembershipFile) Add(host string, port int, comment string) *MembershipEntry {
+	mf.nextLineNumber++
+	entry := &MembershipEntry{Line: mf.nextLineNumber, Host: host, Port: port, Comment: comment}
+	mf.lines = append(mf.lines, &membershipLine{entry: entry})
+	return entry
+}
+
+// Remove drops every entry for the host, with any comment on the same line;
+// it returns how many were removed.
+func (mf *MembershipFile) Remove(host string) int {
+	kept := mf.lines[:0]
+	removed := 0
+	for _, ml := range mf.lines {
+		if ml.entry != nil && strings.EqualFold(ml.entry.Host, host) {
+			removed++
 			continue
 		}
-		hosts = append(hosts, matches[1])
+		kept = append(kept, ml)
+	}
+	mf.lines = kept
+	return removed
+}
+
+// WriteTo writes the file back out; lines are reproduced verbatim unless the
+// entry on them has been changed.
+func (mf *MembershipFile) WriteTo(out io.Writer) (int64, error) {
+	var total int64
+	for i, ml := range mf.lines {
+		text := ml.raw
+		if ml.entry != nil && (ml.raw == "" || *ml.entry != ml.original) {
+			text = ml.entry.String()
+		}
+		if i < len(mf.lines)-1 || !mf.noFinalNewline || ml.raw != text {
+			text += "\n"
+		}
+		n, err := io.WriteString(out, text)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func GetMembershipHosts() ([]string, error) {
+	mf, err := LoadMembershipFile(*flSksMembershipFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range mf.Warnings {
+		Log.Printf("%s: %s", *flSksMembershipFile, w)
 	}
-	return hosts, nil
+	return mf.Hosts(), nil
 }
 
 func GetMembershipAsNodemap() (map[string]*SksNode, error) {
@@ -0,0 +1,139 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Cross-reference our membership file against what the spider saw.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	AuditUnresolvable    = "unresolvable"
+	AuditUnreachable     = "unreachable"
+	AuditNotReciprocated = "not_reciprocating"
+	AuditOutdated        = "outdated"
+	AuditLagging         = "lagging"
+)
+
+// auditLookupHost resolves membership hosts the spider never reached; tests
+// replace it to stay offline.
+var auditLookupHost = net.DefaultResolver.LookupHost
+
+type AuditProblem struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+type MembershipAuditEntry struct {
+	Hostname   string          `json:"hostname"`
+	Canonical  string          `json:"canonical,omitempty"`
+	Software   string          `json:"software,omitempty"`
+	Version    string          `json:"version,omitempty"`
+	Keycount   int             `json:"keycount,omitempty"`
+	KeysBehind int             `json:"keys_behind,omitempty"`
+	Problems   []*AuditProblem `json:"problems"`
+}
+
+func (e *MembershipAuditEntry) problem(code, detail string, v ...interface{}) {
+	e.Problems = append(e.Problems, &AuditProblem{Code: code, Detail: fmt.Sprintf(detail, v...)})
+}
+
+type MembershipAudit struct {
+	Us      string                  `json:"us"`
+	Entries []*MembershipAuditEntry `json:"entries"`
+	// Servers which list us in their gossip peers, but which we don't list.
+	NotInMembership []string `json:"not_in_membership"`
+}
+
+// AuditMembership checks each of the membership hosts against the mesh as
+// seen from us.  Hostnames we never learnt about are looked up in DNS, to
+// tell apart the unresolvable from the unreachable; at most -ip-probe-parallel
+// lookups run at once and all must finish within -http-fetch-timeout.
+func AuditMembership(persisted *PersistedHostInfo, us string, hosts []string) *MembershipAudit {
+	audit := &MembershipAudit{
+		Us:              us,
+		Entries:         make([]*MembershipAuditEntry, len(hosts)),
+		NotInMembership: []string{},
+	}
+	usCanon, haveUs := persisted.Graph.Canonical(us)
+	reference := MeshKeycountReference(persisted.HostMap)
+	latest := MeshLatestVersions(persisted.HostMap)
+	listed := make(map[string]bool, len(hosts))
+
+	ctx, cancel := context.WithTimeout(context.Background(), *flHttpFetchTimeout)
+	defer cancel()
+	limit := make(chan bool, *flIPProbeParallel)
+	var lookups sync.WaitGroup
+	for i, hostname := range hosts {
+		entry := &MembershipAuditEntry{Hostname: hostname, Problems: []*AuditProblem{}}
+		audit.Entries[i] = entry
+
+		canon, known := persisted.AliasMap[strings.ToLower(hostname)]
+		if !known {
+			canon, known = persisted.AliasMap[hostname]
+		}
+		node := persisted.HostMap[canon]
+		if !known || node == nil {
+			lookups.Add(1)
+			limit <- true
+			go func(e *MembershipAuditEntry) {
+				defer func() { <-limit; lookups.Done() }()
+				if _, err := auditLookupHost(ctx, e.Hostname); err != nil {
+					e.problem(AuditUnresolvable, "DNS lookup failed: %s", err)
+				} else {
+					e.problem(AuditUnreachable, "no stats retrieved from the server")
+				}
+			}(entry)
+			continue
+		}
+		entry.Canonical = canon
+		listed[strings.ToLower(canon)] = true
+		if node.AnalyzeError != "" {
+			entry.problem(AuditUnreachable, "stats unusable: %s", node.AnalyzeError)
+			continue
+		}
+		entry.Software = node.SoftwareName()
+		entry.Version = node.Version
+		entry.Keycount = node.Keycount
+		entry.KeysBehind = KeysBehind(node, reference)
+
+		if haveUs && !persisted.Graph.ExistsLink(canon, usCanon) {
+			entry.problem(AuditNotReciprocated, "%s does not list %s as a gossip peer", canon, us)
+		}
+		if outdated, newest := NodeOutdated(node, latest); outdated {
+			entry.problem(AuditOutdated, "runs %s %s, mesh has %s", entry.Software, node.Version, newest)
+		}
+		if entry.KeysBehind > *flKeysLagWarn {
+			entry.problem(AuditLagging, "%d keys behind the mesh reference of %d", entry.KeysBehind, reference)
+		}
+	}
+	lookups.Wait()
+
+	if haveUs {
+		for _, peer := range persisted.Graph.inboundList(strings.ToLower(usCanon)) {
+			if !listed[peer] {
+				audit.NotInMembership = append(audit.NotInMembership, peer)
+			}
+		}
+	}
+	return audit
+}
@@ -0,0 +1,114 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testAuditMembership = `# sks.spodhuis.org membership
+keys.kfwebs.net 11370
+Ranger.KY9K.org 11370
+thesecuregroup.com 11370
+sks1.webtru.st 11370 # stats page gives a 503
+keyserver.stack.nl 11370 # peer we never reached
+gone.example.org 11370
+`
+
+func TestAuditMembership(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	mf, err := ParseMembership(strings.NewReader(testAuditMembership))
+	if err != nil {
+		t.Fatalf("ParseMembership failed: %s", err)
+	}
+
+	var (
+		lock             sync.Mutex
+		looked           []string
+		running, maxSeen int
+	)
+	savedLookup, savedParallel := auditLookupHost, *flIPProbeParallel
+	defer func() { auditLookupHost, *flIPProbeParallel = savedLookup, savedParallel }()
+	*flIPProbeParallel = 1
+	auditLookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lock.Lock()
+		looked = append(looked, host)
+		running++
+		if running > maxSeen {
+			maxSeen = running
+		}
+		lock.Unlock()
+		defer func() { lock.Lock(); running--; lock.Unlock() }()
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("Lookup of %q has no deadline", host)
+		}
+		if strings.HasSuffix(host, ".example.org") {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+
+	audit := AuditMembership(persisted, "sks.spodhuis.org", mf.Hosts())
+
+	for i, tc := range []struct {
+		hostname  string
+		canonical string
+		problems  []string
+	}{
+		{"keys.kfwebs.net", "keys.kfwebs.net", nil},
+		{"Ranger.KY9K.org", "ranger.ky9k.org", []string{AuditOutdated}},
+		{"thesecuregroup.com", "thesecuregroup.com", []string{AuditNotReciprocated}},
+		{"sks1.webtru.st", "sks1.webtru.st", []string{AuditUnreachable}},
+		{"keyserver.stack.nl", "", []string{AuditUnreachable}},
+		{"gone.example.org", "", []string{AuditUnresolvable}},
+	} {
+		entry := audit.Entries[i]
+		if entry.Hostname != tc.hostname || entry.Canonical != tc.canonical {
+			t.Errorf("Entry %d is %q (%q), expected %q (%q)", i, entry.Hostname, entry.Canonical, tc.hostname, tc.canonical)
+			continue
+		}
+		codes := make([]string, len(entry.Problems))
+		for j, p := range entry.Problems {
+			codes[j] = p.Code
+		}
+		if strings.Join(codes, ",") != strings.Join(tc.problems, ",") {
+			t.Errorf("%s: problems %v, expected %v", tc.hostname, codes, tc.problems)
+		}
+	}
+
+	if strings.Join(looked, ",") != "keyserver.stack.nl,gone.example.org" && strings.Join(looked, ",") != "gone.example.org,keyserver.stack.nl" {
+		t.Errorf("Looked up %v, expected only the hosts we never polled", looked)
+	}
+	if maxSeen != 1 {
+		t.Errorf("Saw %d lookups at once with -ip-probe-parallel 1", maxSeen)
+	}
+
+	// Polled servers listing us, which we don't list.
+	inbound := persisted.Graph.inboundList("sks.spodhuis.org")
+	if len(audit.NotInMembership) != len(inbound)-2 {
+		t.Errorf("%d servers not in membership, expected %d", len(audit.NotInMembership), len(inbound)-2)
+	}
+	for _, name := range audit.NotInMembership {
+		if name == "keys.kfwebs.net" || name == "ranger.ky9k.org" {
+			t.Errorf("Listed member %q reported as not in membership", name)
+		}
+	}
+}
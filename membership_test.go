@@ -0,0 +1,104 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testMembership = `# Our peers
+keys.example.org 11370 # Alice <alice@example.org> 0x12345678
+sks.example.net	11370
+[2001:db8::5] 11380 #v6 only
+192.0.2.7 11370
+
+noport.example.com   # Bob
+bad_host!.example.com 11370
+badport.example.com 1137O
+extra.example.com 11370 trailing words
+no-final-newline.example.com 11370`
+
+func TestMembershipParse(t *testing.T) {
+	mf, err := ParseMembership(strings.NewReader(testMembership))
+	if err != nil {
+		t.Fatalf("ParseMembership failed: %s", err)
+	}
+
+	expect := []MembershipEntry{
+		{Line: 2, Host: "keys.example.org", Port: 11370, Comment: " Alice <alice@example.org> 0x12345678"},
+		{Line: 3, Host: "sks.example.net", Port: 11370},
+		{Line: 4, Host: "2001:db8::5", Port: 11380, Comment: "v6 only"},
+		{Line: 5, Host: "192.0.2.7", Port: 11370},
+		{Line: 7, Host: "noport.example.com", Comment: " Bob"},
+		{Line: 10, Host: "extra.example.com", Port: 11370},
+		{Line: 11, Host: "no-final-newline.example.com", Port: 11370},
+	}
+	entries := mf.Entries()
+	if len(entries) != len(expect) {
+		t.Fatalf("Expected %d entries, got %d: %v", len(expect), len(entries), mf.Hosts())
+	}
+	for i := range expect {
+		if *entries[i] != expect[i] {
+			t.Fatalf("Entry %d: expected %+v got %+v", i, expect[i], *entries[i])
+		}
+	}
+
+	warnLines := []int{7, 8, 9, 10}
+	if len(mf.Warnings) != len(warnLines) {
+		t.Fatalf("Expected %d warnings, got %d: %v", len(warnLines), len(mf.Warnings), mf.Warnings)
+	}
+	for i, line := range warnLines {
+		if mf.Warnings[i].Line != line {
+			t.Fatalf("Warning %d: expected line %d, got %s", i, line, mf.Warnings[i])
+		}
+	}
+}
+
+func TestMembershipRoundTrip(t *testing.T) {
+	mf, err := ParseMembership(strings.NewReader(testMembership))
+	if err != nil {
+		t.Fatalf("ParseMembership failed: %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = mf.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo failed: %s", err)
+	}
+	if out.String() != testMembership {
+		t.Fatalf("Round-trip changed the file:\n%s", out.String())
+	}
+
+	mf.Entries()[1].Port = 11380
+	mf.Remove("192.0.2.7")
+	mf.Add("new.example.org", 11370, " Carol")
+	out.Reset()
+	mf.WriteTo(&out)
+	lines := strings.Split(out.String(), "\n")
+	if lines[1] != "keys.example.org 11370 # Alice <alice@example.org> 0x12345678" {
+		t.Fatalf("Unchanged entry was rewritten: %q", lines[1])
+	}
+	if lines[2] != "sks.example.net 11380" {
+		t.Fatalf("Changed entry not rewritten: %q", lines[2])
+	}
+	if strings.Contains(out.String(), "192.0.2.7") {
+		t.Fatalf("Removed entry still present")
+	}
+	if !strings.HasSuffix(out.String(), "no-final-newline.example.com 11370\nnew.example.org 11370 # Carol\n") {
+		t.Fatalf("Added entry not at end of file:\n%s", out.String())
+	}
+}
@@ -0,0 +1,226 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Mesh health analysis over the peering graph.  Recon only happens where both
+// sides have configured the peering, so everything here works on the mutual
+// links and ignores one-sided gossip entries.
+
+import (
+	"sort"
+	"strings"
+)
+
+// meshAdjacency is an undirected graph of mutual peerings, keyed by lower-cased
+// canonical hostname.
+type meshAdjacency map[string]map[string]bool
+
+func newMeshAdjacency(persisted *PersistedHostInfo) meshAdjacency {
+	adj := make(meshAdjacency, len(persisted.HostMap))
+	for hostname := range persisted.HostMap {
+		name := strings.ToLower(hostname)
+		if _, ok := adj[name]; !ok {
+			adj[name] = make(map[string]bool)
+		}
+		for _, peer := range persisted.Graph.MutualPeersOf(name) {
+			adj.link(name, peer)
+		}
+	}
+	return adj
+}
+
+func (adj meshAdjacency) link(a, b string) {
+	if a == b {
+		return
+	}
+	if _, ok := adj[a]; !ok {
+		adj[a] = make(map[string]bool)
+	}
+	if _, ok := adj[b]; !ok {
+		adj[b] = make(map[string]bool)
+	}
+	adj[a][b] = true
+	adj[b][a] = true
+}
+
+func (adj meshAdjacency) remove(name string) {
+	for peer := range adj[name] {
+		delete(adj[peer], name)
+	}
+	delete(adj, name)
+}
+
+func (adj meshAdjacency) copy() meshAdjacency {
+	dup := make(meshAdjacency, len(adj))
+	for name, peers := range adj {
+		dup[name] = make(map[string]bool, len(peers))
+		for peer := range peers {
+			dup[name][peer] = true
+		}
+	}
+	return dup
+}
+
+func (adj meshAdjacency) peersOf(name string) []string {
+	peers := make([]string, 0, len(adj[name]))
+	for peer := range adj[name] {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (adj meshAdjacency) hopsFrom(name string) map[string]int {
+	return breadthFirst([]string{name}, -1, adj.peersOf)
+}
+
+// components returns the connected components, largest first.
+func (adj meshAdjacency) components() [][]string {
+	seen := make(map[string]bool, len(adj))
+	names := make([]string, 0, len(adj))
+	for name := range adj {
+		names = append(names, name)
+	}
+	HostSort(names)
+	var result [][]string
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		component := make([]string, 0, len(adj))
+		for member := range adj.hopsFrom(name) {
+			seen[member] = true
+			component = append(component, member)
+		}
+		HostSort(component)
+		result = append(result, component)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return len(result[i]) > len(result[j]) })
+	return result
+}
+
+// MeshMetrics summarise the connectivity of the mesh of mutual peerings.
+// The diameter is that of the largest component.
+type MeshMetrics struct {
+	Hosts            int      `json:"hosts"`
+	MutualLinks      int      `json:"mutual_links"`
+	Components       int      `json:"components"`
+	LargestComponent int      `json:"largest_component"`
+	Diameter         int      `json:"diameter"`
+	NoMutualPeers    []string `json:"no_mutual_peers"`
+}
+
+func (adj meshAdjacency) metrics() MeshMetrics {
+	m := MeshMetrics{Hosts: len(adj), NoMutualPeers: []string{}}
+	for name, peers := range adj {
+		m.MutualLinks += len(peers)
+		if len(peers) == 0 {
+			m.NoMutualPeers = append(m.NoMutualPeers, name)
+		}
+	}
+	m.MutualLinks /= 2
+	HostSort(m.NoMutualPeers)
+
+	components := adj.components()
+	m.Components = len(components)
+	if len(components) > 0 {
+		m.LargestComponent = len(components[0])
+		for _, name := range components[0] {
+			for _, d := range adj.hopsFrom(name) {
+				if d > m.Diameter {
+					m.Diameter = d
+				}
+			}
+		}
+	}
+	return m
+}
+
+// MeshScenario is a hypothetical change to the mesh: hosts retired and mutual
+// peerings added.
+type MeshScenario struct {
+	Remove []string    `json:"remove"`
+	Add    [][2]string `json:"add"`
+}
+
+// WhatIfResult compares the mesh before and after a MeshScenario.
+type WhatIfResult struct {
+	Scenario MeshScenario `json:"scenario"`
+	Before   MeshMetrics  `json:"before"`
+	After    MeshMetrics  `json:"after"`
+	// Hosts which have mutual peers today but would have none.
+	LoseAllMutualPeers []string `json:"lose_all_mutual_peers"`
+	// Hosts in today's main component which would be cut off from it.
+	CutOffFromMesh []string `json:"cut_off_from_mesh"`
+}
+
+// SimulateMesh applies the scenario to the current mesh and reports the
+// impact.  Hostnames in the scenario are resolved through the alias map and
+// are replaced with their canonical names in the result.
+func SimulateMesh(persisted *PersistedHostInfo, scenario MeshScenario) *WhatIfResult {
+	before := newMeshAdjacency(persisted)
+	after := before.copy()
+
+	canonical := func(name string) string {
+		if canon, ok := persisted.Graph.Canonical(name); ok {
+			return strings.ToLower(canon)
+		}
+		return strings.ToLower(name)
+	}
+	applied := MeshScenario{Remove: []string{}, Add: [][2]string{}}
+	for _, name := range scenario.Remove {
+		name = canonical(name)
+		after.remove(name)
+		applied.Remove = append(applied.Remove, name)
+	}
+	for _, pair := range scenario.Add {
+		a, b := canonical(pair[0]), canonical(pair[1])
+		after.link(a, b)
+		applied.Add = append(applied.Add, [2]string{a, b})
+	}
+
+	result := &WhatIfResult{
+		Scenario:           applied,
+		Before:             before.metrics(),
+		After:              after.metrics(),
+		LoseAllMutualPeers: []string{},
+		CutOffFromMesh:     []string{},
+	}
+	for name, peers := range before {
+		if len(peers) == 0 {
+			continue
+		}
+		if afterPeers, ok := after[name]; ok && len(afterPeers) == 0 {
+			result.LoseAllMutualPeers = append(result.LoseAllMutualPeers, name)
+		}
+	}
+	HostSort(result.LoseAllMutualPeers)
+
+	beforeComponents := before.components()
+	afterComponents := after.components()
+	if len(beforeComponents) > 0 && len(afterComponents) > 0 {
+		stillMain := make(map[string]bool, len(afterComponents[0]))
+		for _, name := range afterComponents[0] {
+			stillMain[name] = true
+		}
+		for _, name := range beforeComponents[0] {
+			if _, exists := after[name]; exists && !stillMain[name] {
+				result.CutOffFromMesh = append(result.CutOffFromMesh, name)
+			}
+		}
+	}
+	return result
+}
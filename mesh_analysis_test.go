@@ -0,0 +1,136 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func loadTestPersisted(t *testing.T) *PersistedHostInfo {
+	if Log == nil {
+		Log = log.New(ioutil.Discard, "", 0)
+	}
+	hostmap, err := LoadJSONFromFile(TEST_DATA_FILE)
+	if err != nil {
+		t.Fatalf("Failed to load \"%s\": %s", TEST_DATA_FILE, err)
+	}
+	hostnames := GenerateHostlistSorted(hostmap)
+	aliasMap := GetAliasMapForHostmap(hostmap)
+	return &PersistedHostInfo{
+		HostMap:      hostmap,
+		AliasMap:     aliasMap,
+		IPCountryMap: IPCountryMap{},
+		Sorted:       hostnames,
+		DepthSorted:  GenerateDepthSorted(hostmap),
+		Graph:        GenerateGraph(hostnames, hostmap, aliasMap),
+	}
+}
+
+// withCurrentPersisted installs persisted as the current scan results for the
+// HTTP handlers; call the returned function to restore the previous results.
+func withCurrentPersisted(persisted *PersistedHostInfo) (restore func()) {
+	currentHostMapLock.Lock()
+	saved := currentHostInfo
+	currentHostInfo = persisted
+	currentHostMapLock.Unlock()
+	return func() {
+		currentHostMapLock.Lock()
+		currentHostInfo = saved
+		currentHostMapLock.Unlock()
+	}
+}
+
+func TestMeshWhatIf(t *testing.T) {
+	persisted := loadTestPersisted(t)
+
+	unchanged := SimulateMesh(persisted, MeshScenario{})
+	if unchanged.Before.Hosts != len(persisted.HostMap) {
+		t.Fatalf("Mesh has %d hosts, expected %d", unchanged.Before.Hosts, len(persisted.HostMap))
+	}
+	if unchanged.Before.Diameter != unchanged.After.Diameter || unchanged.Before.Components != unchanged.After.Components {
+		t.Fatalf("Empty scenario changed the mesh: %+v -> %+v", unchanged.Before, unchanged.After)
+	}
+	if unchanged.Before.Diameter < 1 || unchanged.Before.LargestComponent < 2 {
+		t.Fatalf("Implausible mesh metrics: %+v", unchanged.Before)
+	}
+
+	victim := "keys.kfwebs.net"
+	mutual := persisted.Graph.MutualPeersOf(victim)
+	if len(mutual) == 0 {
+		t.Fatalf("Test host %q has no mutual peers", victim)
+	}
+	isolated := SimulateMesh(persisted, MeshScenario{Remove: mutual})
+	found := false
+	for _, name := range isolated.LoseAllMutualPeers {
+		if name == victim {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Removing all %d mutual peers of %q did not report it as losing them all: %v",
+			len(mutual), victim, isolated.LoseAllMutualPeers)
+	}
+	if isolated.After.Hosts != isolated.Before.Hosts-len(mutual) {
+		t.Fatalf("Removing %d hosts went from %d to %d", len(mutual), isolated.Before.Hosts, isolated.After.Hosts)
+	}
+
+	rejoined := SimulateMesh(persisted, MeshScenario{Remove: mutual, Add: [][2]string{{victim, "sks.spodhuis.org"}}})
+	for _, name := range rejoined.LoseAllMutualPeers {
+		if name == victim {
+			t.Fatalf("Adding a link to %q did not rescue it", victim)
+		}
+	}
+}
+
+func TestWhatIfPage(t *testing.T) {
+	defer withCurrentPersisted(loadTestPersisted(t))()
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"remove=keys.kfwebs.net&add=SKS.Spodhuis.ORG:pgpkeys.co.uk", http.StatusOK},
+		{"add=sks.spodhuis.org:nowhere.example.org", http.StatusBadRequest},
+		{"add=sks.spodhuis.org", http.StatusBadRequest},
+		{"remove=keys.kfwebs.net&add=sks.spodhuis.org:Keys.KFWebs.Net", http.StatusBadRequest},
+		{"remove=nowhere.example.org", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		apiWhatIfPage(rec, httptest.NewRequest("GET", "/sks-peers/what-if?"+tc.query, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, expected %d: %s", tc.query, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	apiWhatIfPage(rec, httptest.NewRequest("GET", "/sks-peers/what-if?add=SKS.Spodhuis.ORG:pgpkeys.co.uk", nil))
+	var result WhatIfResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Bad JSON: %s\n%s", err, rec.Body.String())
+	}
+	if len(result.Scenario.Add) != 1 || result.Scenario.Add[0] != [2]string{"sks.spodhuis.org", "pgpkeys.co.uk"} {
+		t.Errorf("Link not canonicalised: %v", result.Scenario.Add)
+	}
+	if result.After.Hosts != result.Before.Hosts {
+		t.Errorf("Adding a link between known hosts changed the host count: %d -> %d", result.Before.Hosts, result.After.Hosts)
+	}
+}
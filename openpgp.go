@@ -0,0 +1,203 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Just enough OpenPGP (RFC 4880, RFC 9580) to check what keyservers send
+// us: ASCII armour and the packet framing, without any cryptography beyond
+// fingerprints.
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	armorPublicKeyBegin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	armorPublicKeyEnd   = "-----END PGP PUBLIC KEY BLOCK-----"
+
+	packetTagSignature    = 2
+	packetTagPublicKey    = 6
+	packetTagUserID       = 13
+	packetTagPublicSubkey = 14
+	packetTagUserAttr     = 17
+)
+
+func crc24(data []byte) uint32 {
+	crc := uint32(0xB704CE)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// dearmor returns the binary content of the first public key block.
+func dearmor(text string) ([]byte, error) {
+	start := strings.Index(text, armorPublicKeyBegin)
+	if start < 0 {
+		return nil, fmt.Errorf("no armoured public key block")
+	}
+	text = text[start+len(armorPublicKeyBegin):]
+	end := strings.Index(text, armorPublicKeyEnd)
+	if end < 0 {
+		return nil, fmt.Errorf("armoured block not terminated")
+	}
+	lines := strings.Split(strings.Replace(text[:end], "\r", "", -1), "\n")
+
+	// Headers run up to the first blank line.
+	i := 1
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+		if !strings.Contains(lines[i], ": ") {
+			// no headers and no blank line; tolerate it
+			i = 0
+			break
+		}
+	}
+	var b64, checksum strings.Builder
+	for _, line := range lines[i:] {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "=") {
+			checksum.WriteString(line[1:])
+			break
+		}
+		b64.WriteString(line)
+	}
+	data, err := base64.StdEncoding.DecodeString(b64.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad armour: %s", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty armoured block")
+	}
+	if checksum.Len() > 0 {
+		sum, err := base64.StdEncoding.DecodeString(checksum.String())
+		if err != nil || len(sum) != 3 {
+			return nil, fmt.Errorf("bad armour checksum")
+		}
+		if uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) != crc24(data) {
+			return nil, fmt.Errorf("armour checksum mismatch")
+		}
+	}
+	return data, nil
+}
+
+type openpgpPacket struct {
+	tag  int
+	body []byte
+}
+
+// splitPackets does the packet framing; partial lengths are only allowed in
+// data packets, which have no place in a key.
+func splitPackets(data []byte) ([]openpgpPacket, error) {
+	var packets []openpgpPacket
+	for len(data) > 0 {
+		header := data[0]
+		if header&0x80 == 0 {
+			return nil, fmt.Errorf("bad packet header 0x%02x", header)
+		}
+		var tag, length, offset int
+		if header&0x40 != 0 {
+			tag = int(header & 0x3F)
+			if len(data) < 2 {
+				return nil, fmt.Errorf("truncated packet header")
+			}
+			switch first := int(data[1]); {
+			case first < 192:
+				length, offset = first, 2
+			case first < 224:
+				if len(data) < 3 {
+					return nil, fmt.Errorf("truncated packet header")
+				}
+				length, offset = (first-192)<<8+int(data[2])+192, 3
+			case first == 255:
+				if len(data) < 6 {
+					return nil, fmt.Errorf("truncated packet header")
+				}
+				length, offset = int(binary.BigEndian.Uint32(data[2:6])), 6
+			default:
+				return nil, fmt.Errorf("partial length in key packet")
+			}
+		} else {
+			tag = int(header>>2) & 0x0F
+			switch header & 0x03 {
+			case 0:
+				offset = 2
+			case 1:
+				offset = 3
+			case 2:
+				offset = 5
+			default:
+				return nil, fmt.Errorf("indeterminate length in key packet")
+			}
+			if len(data) < offset {
+				return nil, fmt.Errorf("truncated packet header")
+			}
+			for _, b := range data[1:offset] {
+				length = length<<8 | int(b)
+			}
+		}
+		if length < 0 || len(data) < offset+length {
+			return nil, fmt.Errorf("truncated packet, tag %d", tag)
+		}
+		packets = append(packets, openpgpPacket{tag: tag, body: data[offset : offset+length]})
+		data = data[offset+length:]
+	}
+	return packets, nil
+}
+
+// keyFingerprint is of a public key packet body, by its version.
+func keyFingerprint(body []byte) (string, error) {
+	if len(body) < 1 {
+		return "", fmt.Errorf("empty key packet")
+	}
+	switch body[0] {
+	case 4:
+		h := sha1.New()
+		h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+		h.Write(body)
+		return fmt.Sprintf("%X", h.Sum(nil)), nil
+	case 6:
+		h := sha256.New()
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+		h.Write([]byte{0x9B})
+		h.Write(length[:])
+		h.Write(body)
+		return fmt.Sprintf("%X", h.Sum(nil)), nil
+	}
+	return "", fmt.Errorf("unsupported key version %d", body[0])
+}
+
+func primaryFingerprint(data []byte) (string, error) {
+	packets, err := splitPackets(data)
+	if err != nil {
+		return "", err
+	}
+	if len(packets) == 0 || packets[0].tag != packetTagPublicKey {
+		return "", fmt.Errorf("does not start with a public key packet")
+	}
+	return keyFingerprint(packets[0].body)
+}
@@ -0,0 +1,113 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testKeyPacket builds a v4 public key packet with a made-up RSA key, and
+// its fingerprint.
+func testKeyPacket(seed byte) ([]byte, string) {
+	body := []byte{4, 0x5F, 0x00, 0x00, 0x00, 1, 0x00, 0x08, seed, 0x00, 0x02, 0x01, 0x01}
+	h := sha1.New()
+	h.Write([]byte{0x99, 0, byte(len(body))})
+	h.Write(body)
+	packet := append([]byte{0xC0 | packetTagPublicKey, byte(len(body))}, body...)
+	uid := []byte("Test <test@example.org>")
+	packet = append(packet, 0x80|packetTagUserID<<2, byte(len(uid)))
+	return append(packet, uid...), fmt.Sprintf("%X", h.Sum(nil))
+}
+
+func armorForTest(data []byte) string {
+	crc := crc24(data)
+	sum := base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+	return armorPublicKeyBegin + "\nComment: test\n\n" + base64.StdEncoding.EncodeToString(data) + "\n=" + sum + "\n" + armorPublicKeyEnd + "\n"
+}
+
+func TestCanaryKeyChecks(t *testing.T) {
+	data, fpr := testKeyPacket(0xA5)
+	armored := armorForTest(data)
+
+	if status, err := checkArmoredKey([]byte(armored), fpr); err != nil {
+		t.Fatalf("Good key refused: %s", err)
+	} else if !strings.Contains(status, "key ok") {
+		t.Fatalf("Unexpected status %q", status)
+	}
+	other, otherFpr := testKeyPacket(0x5A)
+	if _, err := checkArmoredKey([]byte(armorForTest(other)), fpr); err == nil || !strings.Contains(err.Error(), otherFpr) {
+		t.Fatalf("Wrong key accepted, err: %v", err)
+	}
+	corrupt := strings.Replace(armored, base64.StdEncoding.EncodeToString(data)[:4], "AAAA", 1)
+	if _, err := checkArmoredKey([]byte(corrupt), fpr); err == nil {
+		t.Fatal("Corrupt armour accepted")
+	}
+	if _, err := checkArmoredKey([]byte("<html>No results found</html>"), fpr); err == nil {
+		t.Fatal("Missing armour accepted")
+	}
+
+	index := "info:1:1\npub:" + fpr[24:] + ":1:2048:1600000000::\nuid:Test <test@example.org>:1600000000::\n"
+	if _, err := checkIndexMR([]byte(index), fpr); err != nil {
+		t.Fatalf("Index with long key ID refused: %s", err)
+	}
+	if _, err := checkIndexMR([]byte(index), otherFpr); err == nil {
+		t.Fatal("Index without the key accepted")
+	}
+
+	if keys, err := parseCanaryKeys(" 0x" + strings.ToLower(fpr) + ", "); err != nil || len(keys) != 1 || keys[0] != fpr {
+		t.Fatalf("parseCanaryKeys gave %v, %v", keys, err)
+	}
+	if _, err := parseCanaryKeys("0x12345678"); err == nil {
+		t.Fatal("Short key ID accepted as canary")
+	}
+
+	node := &SksNode{Lookups: []*LookupProbe{
+		{IP: "192.0.2.1", OK: true}, {IP: "192.0.2.1", OK: true},
+		{IP: "2001:db8::1", OK: true}, {IP: "2001:db8::1", OK: false},
+	}}
+	if !node.LookupOK("192.0.2.1") || node.LookupOK("2001:db8::1") || node.LookupOK("192.0.2.2") {
+		t.Fatal("LookupOK wrong")
+	}
+}
+
+func TestCheckIndexMRKeyVersions(t *testing.T) {
+	v4 := "0123456789ABCDEF0123456789ABCDEF01234567"
+	v6 := "00112233445566778899AABBCCDDEEFF00112233445566778899AABBCCDDEEFF"
+	for _, tc := range []struct {
+		fpr, id string
+		found   bool
+	}{
+		{v4, v4, true},
+		{v4, "89ABCDEF01234567", true},
+		{v4, "89abcdef01234567", true},
+		{v4, "0123456789ABCDEF", false},
+		{v4, "01234567", false},
+		{v6, v6, true},
+		{v6, "0011223344556677", true},
+		{v6, "8899AABBCCDDEEFF", false},
+		{v6, "CCDDEEFF", false},
+	} {
+		index := "info:1:1\npub:" + tc.id + ":22:255:1700000000::\n"
+		if _, err := checkIndexMR([]byte(index), tc.fpr); (err == nil) != tc.found {
+			t.Errorf("%d-digit fingerprint, index ID %s: found %v, expected %v", len(tc.fpr), tc.id, err == nil, tc.found)
+		}
+	}
+}
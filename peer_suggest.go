@@ -0,0 +1,160 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Who should a server peer with?  We prefer candidates which are healthy, up
+// to date, in sync, in a country the server doesn't already reach, and which
+// are themselves short of peers; each suggestion carries the reasons.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	suggestWeightCurrent     = 2.0
+	suggestWeightSynced      = 2.0
+	suggestWeightNewCountry  = 1.5
+	suggestWeightUnderPeered = 1.0
+	suggestWeightReciprocal  = 1.0
+)
+
+// PeerSuggestionRequest names the server wanting peers; with no Host, it's a
+// hypothetical new server in Country (which may also be empty).
+type PeerSuggestionRequest struct {
+	Host    string
+	Country string
+	Limit   int
+}
+
+type PeerSuggestion struct {
+	Hostname    string   `json:"hostname"`
+	Score       float64  `json:"score"`
+	Country     string   `json:"country"`
+	Software    string   `json:"software"`
+	Version     string   `json:"version"`
+	Keycount    int      `json:"keycount"`
+	KeysBehind  int      `json:"keys_behind"`
+	MutualPeers int      `json:"mutual_peers"`
+	Reasons     []string `json:"reasons"`
+}
+
+// SuggestPeers ranks the healthy servers which the requesting server does not
+// already peer with.
+func SuggestPeers(persisted *PersistedHostInfo, request PeerSuggestionRequest) ([]*PeerSuggestion, error) {
+	var (
+		self         string
+		alreadyPeers = make(map[string]bool)
+		listsSelf    = make(map[string]bool)
+		countries    = make(map[string]bool)
+	)
+	if request.Host != "" {
+		canon, ok := persisted.Graph.Canonical(request.Host)
+		if !ok {
+			return nil, fmt.Errorf("Unknown host %q", request.Host)
+		}
+		self = strings.ToLower(canon)
+		for _, peer := range persisted.Graph.outboundList(self) {
+			alreadyPeers[peer] = true
+			if country := persisted.IPCountryMap.CountryForNode(persisted.HostMap[peer]); country != "" {
+				countries[country] = true
+			}
+		}
+		for _, peer := range persisted.Graph.inboundList(self) {
+			listsSelf[peer] = true
+		}
+		if request.Country == "" {
+			request.Country = persisted.IPCountryMap.CountryForNode(persisted.HostMap[canon])
+		}
+	}
+	if request.Country != "" {
+		countries[strings.ToUpper(request.Country)] = true
+	}
+
+	reference := MeshKeycountReference(persisted.HostMap)
+	latest := MeshLatestVersions(persisted.HostMap)
+	typicalPeers := medianMutualPeers(persisted)
+
+	suggestions := make([]*PeerSuggestion, 0, len(persisted.HostMap))
+	for hostname, node := range persisted.HostMap {
+		name := strings.ToLower(hostname)
+		if name == self || alreadyPeers[name] || !NodeHealthy(node) {
+			continue
+		}
+		s := &PeerSuggestion{
+			Hostname:    hostname,
+			Country:     persisted.IPCountryMap.CountryForNode(node),
+			Software:    node.SoftwareName(),
+			Version:     node.Version,
+			Keycount:    node.Keycount,
+			KeysBehind:  KeysBehind(node, reference),
+			MutualPeers: len(persisted.Graph.MutualPeersOf(name)),
+		}
+
+		if outdated, newest := NodeOutdated(node, latest); newest == nil {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("version %q not comparable", node.Version))
+		} else if outdated {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("runs %s %s, behind latest %s", s.Software, node.Version, newest))
+		} else {
+			s.Score += suggestWeightCurrent
+			s.Reasons = append(s.Reasons, fmt.Sprintf("runs current %s %s", s.Software, node.Version))
+		}
+
+		switch {
+		case s.KeysBehind <= *flKeysDailyJitter:
+			s.Score += suggestWeightSynced
+			s.Reasons = append(s.Reasons, "keycount in sync with the mesh")
+		case s.KeysBehind <= *flKeysLagWarn:
+			s.Score += suggestWeightSynced / 2
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d keys behind the mesh", s.KeysBehind))
+		default:
+			s.Reasons = append(s.Reasons, fmt.Sprintf("lagging, %d keys behind the mesh", s.KeysBehind))
+		}
+
+		switch {
+		case s.Country == "":
+			s.Reasons = append(s.Reasons, "location unknown")
+		case !countries[s.Country]:
+			s.Score += suggestWeightNewCountry
+			s.Reasons = append(s.Reasons, fmt.Sprintf("adds a peer in %s", s.Country))
+		}
+
+		if typicalPeers > 0 && s.MutualPeers < typicalPeers {
+			s.Score += suggestWeightUnderPeered * float64(typicalPeers-s.MutualPeers) / float64(typicalPeers)
+			s.Reasons = append(s.Reasons, fmt.Sprintf("under-peered, %d mutual peers against a typical %d", s.MutualPeers, typicalPeers))
+		}
+
+		if listsSelf[name] {
+			s.Score += suggestWeightReciprocal
+			s.Reasons = append(s.Reasons, fmt.Sprintf("already lists %s; peering back makes it mutual", request.Host))
+		}
+
+		suggestions = append(suggestions, s)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return hostCompare(suggestions[i].Hostname, suggestions[j].Hostname) < 0
+	})
+	if request.Limit > 0 && len(suggestions) > request.Limit {
+		suggestions = suggestions[:request.Limit]
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSuggestPeers(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	host := "sks.spodhuis.org"
+
+	all, err := SuggestPeers(persisted, PeerSuggestionRequest{Host: host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 45 {
+		t.Fatalf("Got %d suggestions for %q, expected 45", len(all), host)
+	}
+	existing := make(map[string]bool)
+	for _, peer := range persisted.Graph.outboundList(host) {
+		existing[peer] = true
+	}
+	for i, s := range all {
+		if strings.EqualFold(s.Hostname, host) || existing[strings.ToLower(s.Hostname)] {
+			t.Errorf("Suggested %q, which is %q or already a peer", s.Hostname, host)
+		}
+		if !NodeHealthy(persisted.HostMap[s.Hostname]) {
+			t.Errorf("Suggested unhealthy %q", s.Hostname)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := all[i-1]
+		if prev.Score < s.Score || (prev.Score == s.Score && hostCompare(prev.Hostname, s.Hostname) > 0) {
+			t.Errorf("Suggestions %d and %d out of order: %q %.3f, %q %.3f",
+				i-1, i, prev.Hostname, prev.Score, s.Hostname, s.Score)
+		}
+	}
+
+	// Current software and in sync, plus under-peered, beats current and in
+	// sync alone; ties are in host order.
+	for i, want := range []string{
+		"thesecuregroup.com",
+		"disunitedstates.com",
+		"keyserver.secretresearchfacility.com",
+		"pgp.rediris.es",
+		"pks.aaiedu.hr",
+		"orion.stueve.us",
+	} {
+		if all[i].Hostname != want {
+			t.Errorf("Suggestion %d is %q, expected %q", i, all[i].Hostname, want)
+		}
+	}
+	if last := all[len(all)-1]; last.Hostname != "services" || last.KeysBehind <= *flKeysLagWarn {
+		t.Errorf("Expected the lagging, outdated %q last, got %q", "services", last.Hostname)
+	}
+
+	limited, err := SuggestPeers(persisted, PeerSuggestionRequest{Host: strings.ToUpper(host), Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 3 || limited[0].Hostname != all[0].Hostname || limited[2].Hostname != all[2].Hostname {
+		t.Errorf("Limit 3 (by upper-cased name) did not give the top 3: %v", limited)
+	}
+
+	if _, err := SuggestPeers(persisted, PeerSuggestionRequest{Host: "nowhere.example.org"}); err == nil {
+		t.Error("Expected an error for an unknown host")
+	}
+}
+
+func TestSuggestPeersUnparseableVersion(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	persisted.HostMap["pks.aaiedu.hr"].Version = "garbage"
+
+	suggestions, err := SuggestPeers(persisted, PeerSuggestionRequest{Host: "sks.spodhuis.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range suggestions {
+		if s.Hostname != "pks.aaiedu.hr" {
+			continue
+		}
+		// Only the keycount being in sync counts for it.
+		if s.Score != suggestWeightSynced || !strings.Contains(strings.Join(s.Reasons, "; "), "not comparable") {
+			t.Errorf("Unparseable version scored %.3f: %v", s.Score, s.Reasons)
+		}
+		return
+	}
+	t.Error("pks.aaiedu.hr not suggested")
+}
+
+func TestSuggestPeersNewCountry(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	for _, ip := range persisted.HostMap["pks.aaiedu.hr"].IpList {
+		persisted.IPCountryMap[ip] = "HR"
+	}
+
+	for _, tc := range []struct {
+		country string
+		first   string
+	}{
+		{"", "pks.aaiedu.hr"},
+		{"de", "pks.aaiedu.hr"},
+		{"hr", "thesecuregroup.com"},
+	} {
+		suggestions, err := SuggestPeers(persisted, PeerSuggestionRequest{Country: tc.country})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suggestions[0].Hostname != tc.first {
+			t.Errorf("New server in %q: first suggestion %q, expected %q", tc.country, suggestions[0].Hostname, tc.first)
+		}
+	}
+}
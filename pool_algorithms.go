@@ -0,0 +1,346 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// The ways of deciding which keycounts are plausible and how many keys a
+// server needs to be in the pool.  The tags are public statements; history:
+//   alg_1 used a fixed threshold (too small to deal with jitter)
+//   alg_2 used stddev+jitter
+//   alg_3 fixed maximum bucket selection (was a code bug)
+//   alg_4 stopped double-counting servers with multiple IP addresses
+//   alg_5 keep 1.0.10 servers for long enough to calculate stats, drop afterwards
+//   alg_6 median and MAD in place of the bucket mode and stddev
+//   alg_7 a percentile of the plausible servers, less jitter
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// PoolInput holds the keycounts to judge: PerServer has one IP per server,
+// so that dual-stack boxes are not double-weighted in the statistics, while
+// All has every candidate IP.
+type PoolInput struct {
+	PerServer  map[string]int
+	All        map[string]int
+	BucketSize int
+	Jitter     int
+	Percentile float64
+	Statsf     func(string, ...interface{})
+}
+
+// PoolCut is what an algorithm decided: the IPs with plausible keycounts, a
+// central value for sanity-checking, and the threshold.  If Invalid is set,
+// the rest is meaningless.
+type PoolCut struct {
+	InBounds   map[string]int
+	BoundsMin  int
+	BoundsMax  int
+	Center     float64
+	CenterName string
+	Threshold  int
+	Invalid    string
+}
+
+type PoolAlgorithm interface {
+	Tag() string
+	Cut(in *PoolInput) *PoolCut
+}
+
+const DefaultPoolAlgorithm = "alg_5"
+
+var poolAlgorithms = map[string]PoolAlgorithm{
+	"alg_5": bucketModeAlgorithm{},
+	"alg_6": medianMADAlgorithm{},
+	"alg_7": percentileAlgorithm{},
+}
+
+// PoolAlgorithmByTag returns the default for an empty tag and nil for an
+// unknown one.
+func PoolAlgorithmByTag(tag string) PoolAlgorithm {
+	if tag == "" {
+		tag = DefaultPoolAlgorithm
+	}
+	return poolAlgorithms[tag]
+}
+
+func PoolAlgorithmTags() []string {
+	tags := make([]string, 0, len(poolAlgorithms))
+	for tag := range poolAlgorithms {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// inBounds picks the IPs of All whose keycount is within [min, max].
+func (in *PoolInput) inBounds(min, max int) map[string]int {
+	kept := make(map[string]int, len(in.All))
+	for ip, count := range in.All {
+		if min <= count && count <= max {
+			kept[ip] = count
+		}
+	}
+	return kept
+}
+
+// perServerInBounds is the PerServer keycounts, as seen in All, within
+// [min, max], sorted ascending.
+func (in *PoolInput) perServerInBounds(min, max int) []int {
+	counts := make([]int, 0, len(in.PerServer))
+	for ip := range in.PerServer {
+		if count := in.All[ip]; min <= count && count <= max {
+			counts = append(counts, count)
+		}
+	}
+	sort.Ints(counts)
+	return counts
+}
+
+// perServerCounts is every per-server count, sorted.
+func (in *PoolInput) perServerCounts() []int {
+	counts := make([]int, 0, len(in.PerServer))
+	for ip := range in.PerServer {
+		counts = append(counts, in.All[ip])
+	}
+	sort.Ints(counts)
+	return counts
+}
+
+type bucketModeAlgorithm struct{}
+
+func (bucketModeAlgorithm) Tag() string { return "alg_5" }
+
+// We want to discard statistic-distorting outliers, then of what remains,
+// discard those too far away from "normal", but we really want the "best"
+// servers to be our guide, so 1 std-dev of the second-highest remaining
+// value should be safe; in fact, we'll hardcode a limit of how far below.
+// To discard, find mode size (knowing that value can be split across two
+// buckets) and discard more than five stddevs from mode.  The bucketing
+// should be larger than the distance from desired value so that the mode
+// is only split across two buckets, if we assume enough servers that a
+// small number will be down, most will be valid-if-large-enough, so that
+// splitting the count across two buckets won't let the third-best value win
+func (bucketModeAlgorithm) Cut(in *PoolInput) *PoolCut {
+	Statsf := in.Statsf
+	ips_one_per_server, ips_all := in.PerServer, in.All
+
+	// This is barely-modified from Python, just enough to translate language, not idioms
+	// This was ... "much easier" with list comprehensions in Python
+	var buckets = make(map[int][]int, 40)
+	for _, count := range ips_one_per_server {
+		bucket := int(count / in.BucketSize)
+		if _, ok := buckets[bucket]; !ok {
+			buckets[bucket] = make([]int, 0, 20)
+		}
+		buckets[bucket] = append(buckets[bucket], count)
+	}
+	if len(buckets) == 0 {
+		return &PoolCut{Invalid: "broken_no_buckets"}
+	}
+
+	// Ties go to the higher bucket, so that the choice doesn't depend on map
+	// iteration order.
+	var largest_bucket int
+	var largest_bucket_len int
+	for k := range buckets {
+		if len(buckets[k]) > largest_bucket_len || (len(buckets[k]) == largest_bucket_len && k > largest_bucket) {
+			largest_bucket = k
+			largest_bucket_len = len(buckets[k])
+		}
+	}
+	first_n := len(buckets[largest_bucket])
+	var first_sum int
+	for _, v := range buckets[largest_bucket] {
+		first_sum += v
+	}
+	first_mean := float64(first_sum) / float64(first_n)
+	var first_sd float64
+	for _, v := range buckets[largest_bucket] {
+		d := float64(v) - first_mean
+		first_sd += d * d
+	}
+	first_sd = math.Sqrt(first_sd / float64(first_n))
+	first_bounds_min := int(first_mean - 5*first_sd)
+	first_bounds_max := int(first_mean + 5*first_sd)
+
+	first_ips := in.perServerInBounds(first_bounds_min, first_bounds_max)
+	var second_mean, second_sd float64
+	for _, count := range first_ips {
+		second_mean += float64(count)
+	}
+	second_mean /= float64(len(first_ips))
+	for _, v := range first_ips {
+		d := float64(v) - second_mean
+		second_sd += d * d
+	}
+	second_sd = math.Sqrt(second_sd / float64(len(first_ips)))
+
+	Statsf("have %d servers in %d buckets (%d ips total)", len(ips_one_per_server), len(buckets), len(ips_all))
+	bucket_sizes := make([]int, 0, len(buckets))
+	for k := range buckets {
+		bucket_sizes = append(bucket_sizes, k)
+	}
+	sort.Ints(bucket_sizes)
+	for _, b := range bucket_sizes {
+		Statsf("%6d: %s", b, strings.Repeat("*", len(buckets[b])))
+	}
+	Statsf("largest bucket is %d with %d entries", largest_bucket, first_n)
+	Statsf("bucket size %d means bucket %d is [%d, %d)", in.BucketSize, largest_bucket,
+		in.BucketSize*largest_bucket, in.BucketSize*(largest_bucket+1))
+	Statsf("largest bucket: mean=%f sd=%f", first_mean, first_sd)
+	Statsf("first bounds: [%d, %d]", first_bounds_min, first_bounds_max)
+	Statsf("have %d servers within bounds, mean value %f sd=%f", len(first_ips), second_mean, second_sd)
+
+	if len(first_ips) == 0 {
+		return &PoolCut{Invalid: "broken_data"}
+	}
+	cut := &PoolCut{
+		InBounds:   in.inBounds(first_bounds_min, first_bounds_max),
+		BoundsMin:  first_bounds_min,
+		BoundsMax:  first_bounds_max,
+		Center:     second_mean,
+		CenterName: "mean",
+	}
+	threshold_base_index := len(first_ips) - 2
+	if threshold_base_index < 0 {
+		threshold_base_index = 0
+	}
+	cut.Threshold = first_ips[threshold_base_index] - (in.Jitter + int(second_sd))
+	Statsf("Second largest count within bounds: %d", first_ips[threshold_base_index])
+	Statsf("threshold: %d", cut.Threshold)
+	return cut
+}
+
+// madScale makes the median absolute deviation comparable with a standard
+// deviation, for normally distributed data.
+const madScale = 1.4826
+
+// medianMAD gives the median of the values and their scaled median absolute
+// deviation; the values must be sorted.
+func medianMAD(sorted []int) (median, mad float64) {
+	median = medianSortedFloat(sorted)
+	deviations := make([]int, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = int(math.Abs(float64(v) - median))
+	}
+	sort.Ints(deviations)
+	return median, madScale * medianSortedFloat(deviations)
+}
+
+func medianSortedFloat(sorted []int) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return float64(sorted[n/2])
+	}
+	return (float64(sorted[n/2-1]) + float64(sorted[n/2])) / 2
+}
+
+// robustBounds is the median plus or minus five scaled MADs.  When most
+// servers agree exactly the MAD is zero, so the daily jitter is the least
+// spread allowed.
+func robustBounds(in *PoolInput) (median, spread float64, min, max int, ok bool) {
+	all := in.perServerCounts()
+	if len(all) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	median, spread = medianMAD(all)
+	if spread < float64(in.Jitter) {
+		spread = float64(in.Jitter)
+	}
+	in.Statsf("have %d servers (%d ips total): median=%f mad=%f", len(all), len(in.All), median, spread)
+	min, max = int(median-5*spread), int(median+5*spread)
+	in.Statsf("bounds: [%d, %d]", min, max)
+	return median, spread, min, max, true
+}
+
+type medianMADAlgorithm struct{}
+
+func (medianMADAlgorithm) Tag() string { return "alg_6" }
+
+// Cut keeps alg_5's choice of threshold, the second-highest plausible count
+// less jitter and spread, but finds the plausible counts with the median and
+// MAD, which a cluster of broken servers can't drag around.
+func (medianMADAlgorithm) Cut(in *PoolInput) *PoolCut {
+	median, spread, min, max, ok := robustBounds(in)
+	if !ok {
+		return &PoolCut{Invalid: "broken_no_servers"}
+	}
+	counts := in.perServerInBounds(min, max)
+	if len(counts) == 0 {
+		return &PoolCut{Invalid: "broken_data"}
+	}
+	base := len(counts) - 2
+	if base < 0 {
+		base = 0
+	}
+	cut := &PoolCut{
+		InBounds:   in.inBounds(min, max),
+		BoundsMin:  min,
+		BoundsMax:  max,
+		Center:     median,
+		CenterName: "median",
+		Threshold:  counts[base] - (in.Jitter + int(spread)),
+	}
+	in.Statsf("have %d servers within bounds; second largest count %d", len(counts), counts[base])
+	in.Statsf("threshold: %d", cut.Threshold)
+	return cut
+}
+
+const defaultPoolPercentile = 50
+
+type percentileAlgorithm struct{}
+
+func (percentileAlgorithm) Tag() string { return "alg_7" }
+
+// Cut takes the threshold as the given percentile of the plausible servers'
+// counts, less jitter: by default, a server must be within a day's jitter of
+// the typical server.
+func (percentileAlgorithm) Cut(in *PoolInput) *PoolCut {
+	median, _, min, max, ok := robustBounds(in)
+	if !ok {
+		return &PoolCut{Invalid: "broken_no_servers"}
+	}
+	counts := in.perServerInBounds(min, max)
+	if len(counts) == 0 {
+		return &PoolCut{Invalid: "broken_data"}
+	}
+	pct := in.Percentile
+	if pct <= 0 || pct > 100 {
+		pct = defaultPoolPercentile
+	}
+	// nearest-rank
+	rank := int(math.Ceil(pct/100*float64(len(counts)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	cut := &PoolCut{
+		InBounds:   in.inBounds(min, max),
+		BoundsMin:  min,
+		BoundsMax:  max,
+		Center:     median,
+		CenterName: "median",
+		Threshold:  counts[rank] - in.Jitter,
+	}
+	in.Statsf("have %d servers within bounds; percentile %g is %d", len(counts), pct, counts[rank])
+	in.Statsf("threshold: %d", cut.Threshold)
+	return cut
+}
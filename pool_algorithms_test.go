@@ -0,0 +1,179 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMedianMAD(t *testing.T) {
+	median, mad := medianMAD([]int{1, 2, 3, 4, 100})
+	if median != 3 || mad != madScale*1 {
+		t.Errorf("expected median 3 and MAD %f, got %f %f", madScale, median, mad)
+	}
+}
+
+// poolInputOf gives each server one IP, named for its index.
+func poolInputOf(counts ...int) *PoolInput {
+	in := &PoolInput{
+		PerServer:  make(map[string]int, len(counts)),
+		All:        make(map[string]int, len(counts)),
+		BucketSize: 3000,
+		Jitter:     800,
+		Statsf:     func(string, ...interface{}) {},
+	}
+	for i, c := range counts {
+		ip := fmt.Sprintf("192.0.2.%d", i+1)
+		in.PerServer[ip] = c
+		in.All[ip] = c
+	}
+	return in
+}
+
+func TestRobustAlgorithms(t *testing.T) {
+	// Five healthy servers spread over a couple of days' updates, three
+	// stuck well behind together, and one miscounting far ahead.
+	in := poolInputOf(5000000, 5001000, 5002000, 5003000, 5004000, 4900000, 4900100, 4900200, 9000000)
+
+	cut := medianMADAlgorithm{}.Cut(in)
+	if cut.Invalid != "" {
+		t.Fatalf("alg_6: %s", cut.Invalid)
+	}
+	// The MAD comes from the healthy majority, so both the stuck cluster
+	// and the miscounting server are implausible.
+	if len(cut.InBounds) != 5 {
+		t.Errorf("alg_6 kept %d servers, expected the 5 healthy ones: %v", len(cut.InBounds), cut.InBounds)
+	}
+	if cut.Center != 5001000 {
+		t.Errorf("alg_6 center %f, expected the median 5001000", cut.Center)
+	}
+	_, spread, _, _, _ := robustBounds(in)
+	if want := 5003000 - 800 - int(spread); cut.Threshold != want {
+		t.Errorf("alg_6 threshold %d, expected second largest less jitter and spread, %d", cut.Threshold, want)
+	}
+
+	cut = percentileAlgorithm{}.Cut(in)
+	if cut.Invalid != "" {
+		t.Fatalf("alg_7: %s", cut.Invalid)
+	}
+	// Five plausible servers; the nearest-rank median is the third.
+	if cut.Threshold != 5002000-800 {
+		t.Errorf("alg_7 threshold %d, expected %d", cut.Threshold, 5002000-800)
+	}
+	in.Percentile = 100
+	if cut = (percentileAlgorithm{}).Cut(in); cut.Threshold != 5004000-800 {
+		t.Errorf("alg_7 100th percentile threshold %d, expected %d", cut.Threshold, 5004000-800)
+	}
+
+	if cut := (medianMADAlgorithm{}).Cut(poolInputOf()); cut.Invalid == "" {
+		t.Error("alg_6 with no servers should be invalid")
+	}
+}
+
+func TestPoolAlgorithmsFixture(t *testing.T) {
+	ps, _ := fixtureSelector(t)
+	for _, tc := range []struct {
+		tag       string
+		threshold int
+		ips       int
+	}{
+		{"alg_5", 3168042, 112},
+		{"alg_6", 3167405, 112},
+		{"alg_7", 3168189, 112},
+	} {
+		result := ps.Select(SelectionPolicy{Algorithm: tc.tag})
+		if result.Status != SelectionComplete {
+			t.Errorf("%s: %s %s", tc.tag, result.Status, result.Reason)
+			continue
+		}
+		if result.Tags[len(result.Tags)-1] != tc.tag {
+			t.Errorf("%s: tagged %v", tc.tag, result.Tags)
+		}
+		if result.Threshold != tc.threshold || len(result.IPs) != tc.ips {
+			t.Errorf("%s: threshold %d with %d IPs, expected %d with %d",
+				tc.tag, result.Threshold, len(result.IPs), tc.threshold, tc.ips)
+		}
+	}
+	if len(PoolAlgorithmTags()) != 3 {
+		t.Errorf("have algorithms %v, expected fixture results for each", PoolAlgorithmTags())
+	}
+	if r := ps.Select(SelectionPolicy{Algorithm: "alg_0"}); r.Reason != "unknown_algorithm" {
+		t.Errorf("unknown algorithm: got %s %s", r.Status, r.Reason)
+	}
+
+	// In this snapshot the default bucket finds the same threshold as the old
+	// fixed 3000-key bucket, to within the jitter.
+	base := ps.Select(SelectionPolicy{}).Threshold
+	ps.BucketSize = 3000
+	if coarse := ps.Select(SelectionPolicy{}).Threshold; coarse < base-ps.Jitter || coarse > base+ps.Jitter {
+		t.Errorf("bucket 3000 threshold %d, default bucket %d", coarse, base)
+	}
+}
+
+func TestDefaultBucketSize(t *testing.T) {
+	savedBucket, savedJitter := *flIPValidBucket, *flKeysDailyJitter
+	defer func() { *flIPValidBucket, *flKeysDailyJitter = savedBucket, savedJitter }()
+
+	*flIPValidBucket, *flKeysDailyJitter = 0, 800
+	if b := defaultBucketSize(); b != 1600 {
+		t.Errorf("default bucket %d, expected twice the jitter", b)
+	}
+	*flIPValidBucket = 5000
+	if b := NewPoolSelector(nil).BucketSize; b != 5000 {
+		t.Errorf("-ip-valid-bucket-size 5000 gave bucket %d", b)
+	}
+
+	// Four servers in sync, three about two days behind.  A 3000-key bucket
+	// puts them all in one mode, whose spread lowers the threshold enough to
+	// let a laggard in; twice the jitter keeps the mode to those in sync.
+	laggard := 6004000
+	in := poolInputOf(6005100, 6005300, 6005500, 6005700, 6003800, 6003900, laggard)
+	in.BucketSize = 3000
+	if cut := (bucketModeAlgorithm{}).Cut(in); cut.Threshold > laggard {
+		t.Errorf("bucket 3000 threshold %d now excludes the laggard at %d; rethink this test", cut.Threshold, laggard)
+	}
+	*flIPValidBucket = 0
+	in.BucketSize = defaultBucketSize()
+	if cut := (bucketModeAlgorithm{}).Cut(in); cut.Threshold <= laggard || cut.Threshold > 6005500-800 {
+		t.Errorf("default bucket threshold %d, expected above the laggard at %d", cut.Threshold, laggard)
+	}
+}
+
+func TestIpValidCompare(t *testing.T) {
+	defer withFixturePersisted(t)()
+
+	rec := httptest.NewRecorder()
+	apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?alg=alg_7&compare", nil))
+	body := rec.Body.String()
+	for _, tag := range PoolAlgorithmTags() {
+		if !strings.Contains(body, "STATS: ["+tag+"] status=COMPLETE") {
+			t.Errorf("no comparison line for %s in:\n%s", tag, body)
+		}
+	}
+	if !strings.Contains(body, "tags=skip_1010,alg_7") {
+		t.Errorf("requested algorithm not tagged:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?alg=alg_99", nil))
+	if rec.Code != 400 {
+		t.Errorf("unknown alg: expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// The selection of IPs for the ip-valid pool, kept apart from HTTP so that it
+// can be tested against saved snapshots and run offline.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SelectionComplete = "COMPLETE"
+	SelectionInvalid  = "INVALID"
+)
+
+// SelectionPolicy is what the pool consumer asked for; the zero value takes
+// every server which passes the statistical cut.
+type SelectionPolicy struct {
+	MinimumVersion *SksVersion
+	ProxiesOnly    bool
+	LookupOK       bool
+	Countries      CountrySet
+	Threshold      int     // overrides the computed threshold if > 0
+	Algorithm      string  // tag of the PoolAlgorithm; empty for the default
+	Percentile     float64 // for those algorithms which take one; 0 for their default
+	Trace          bool    // record each candidate IP's journey in the result
+}
+
+// StageCount records one step of the selection: how many servers it dropped
+// and how many IPs remained afterwards.
+type StageCount struct {
+	Stage   string `json:"stage"`
+	Servers int    `json:"servers_dropped"`
+	IPs     int    `json:"ips_remaining"`
+}
+
+type SelectionResult struct {
+	Status       string
+	Reason       string // why the result is INVALID
+	IPs          []string
+	Threshold    int
+	Tags         []string
+	Considered   int // IPs which went into the selection
+	NotAnswering int // IPs left out for failing their probe
+	Stages       []StageCount
+	Stats        []string
+	Trace        []*IPTrace // only if the policy asked; in host order
+}
+
+type PoolSelector struct {
+	persisted  *PersistedHostInfo
+	BucketSize int
+	Jitter     int
+	SanityMin  int
+}
+
+// defaultBucketSize is what alg_5 buckets keycounts by, unless the operator
+// says otherwise.  It used to be a fixed 3000 keys, several days' worth of
+// drift at today's update rates, which let servers days behind share the
+// mode with those in sync.  Servers in sync are within a day's jitter of each
+// other, so a bucket of twice the jitter still splits their mode across at
+// most two buckets, as alg_5 assumes, while leaving the laggards outside.
+func defaultBucketSize() int {
+	if *flIPValidBucket > 0 {
+		return *flIPValidBucket
+	}
+	if *flKeysDailyJitter > 0 {
+		return 2 * *flKeysDailyJitter
+	}
+	return 1
+}
+
+// NewPoolSelector takes its tunables from the command-line flags; persisted
+// may be nil, before the first scan completes.
+func NewPoolSelector(persisted *PersistedHostInfo) *PoolSelector {
+	return &PoolSelector{
+		persisted:  persisted,
+		BucketSize: defaultBucketSize(),
+		Jitter:     *flKeysDailyJitter,
+		SanityMin:  *flKeysSanityMin,
+	}
+}
+
+func (ps *PoolSelector) Select(policy SelectionPolicy) *SelectionResult {
+	// skip 1.0.10 -> skip_1010, because of lookup problems biting gnupg;
+	// the algorithm tags are explained with the algorithms.
+	algorithm := PoolAlgorithmByTag(policy.Algorithm)
+	if algorithm == nil {
+		return &SelectionResult{Status: SelectionInvalid, Reason: "unknown_algorithm"}
+	}
+	result := &SelectionResult{
+		Status: SelectionComplete,
+		Tags:   []string{"skip_1010", algorithm.Tag()},
+		Stats:  make([]string, 0, 100),
+	}
+	Statsf := func(s string, v ...interface{}) {
+		result.Stats = append(result.Stats, fmt.Sprintf(s, v...))
+	}
+	// set once there's something to trace
+	var traceAll func(invalid string)
+	invalid := func(reason string) *SelectionResult {
+		if traceAll != nil {
+			traceAll(reason)
+		}
+		result.Status = SelectionInvalid
+		result.Reason = reason
+		result.IPs = nil
+		return result
+	}
+	stage := func(name string, servers, ips int) {
+		result.Stages = append(result.Stages, StageCount{Stage: name, Servers: servers, IPs: ips})
+	}
+
+	persisted := ps.persisted
+	if persisted == nil {
+		return invalid("first_scan")
+	}
+
+	var (
+		// for stats, we avoid double-weighting dual-stack boxes by working with
+		// just one IP per box, but then later deal with all the IPs for filtering.
+		ips_one_per_server = make(map[string]int, len(persisted.HostMap)*2)
+		ips_all            = make(map[string]int, len(persisted.HostMap)*2)
+	)
+
+	var (
+		count_servers_1010            int
+		count_servers_too_old         int
+		count_servers_unwanted_server int
+		count_servers_wrong_country   int
+		count_ips_not_answering       int
+		count_servers_lookup_failed   int
+		ips_skip_1010                 = newSortedSet()
+		ips_too_old                   = newSortedSet()
+		ips_unwanted_server           = newSortedSet()
+		ips_wrong_country             = newSortedSet()
+		ips_lookup_failed             = newSortedSet()
+	)
+
+	var (
+		cut       *PoolCut
+		threshold int
+		reached   = traceCollected // how far the selection got, for the trace
+	)
+	if policy.Trace {
+		traceAll = func(invalid string) {
+			t := &selectionTrace{
+				policy:    &policy,
+				persisted: persisted,
+				cut:       cut,
+				threshold: threshold,
+				reached:   reached,
+				invalid:   invalid,
+				selected:  result.IPs,
+				eliminated: map[string]sortedSet{
+					"skip_1010":       ips_skip_1010,
+					"minimum_version": ips_too_old,
+					"countries":       ips_wrong_country,
+					"lookup_ok":       ips_lookup_failed,
+					"proxies":         ips_unwanted_server,
+				},
+			}
+			result.Trace = t.build()
+		}
+	}
+
+	for _, name := range persisted.Sorted {
+		node := persisted.HostMap[name]
+		var (
+			skip_this_1010     = false
+			skip_this_age      = false
+			skip_this_nonproxy = false
+			skip_this_country  = false
+			skip_this_lookup   = false
+		)
+		if node.Keycount <= 1 {
+			Statsf("dropping server <%s> with %d keys", name, node.Keycount)
+			continue
+		}
+
+		if string(node.Version) == "1.0.10" {
+			skip_this_1010 = true
+			count_servers_1010 += 1
+		}
+
+		if policy.MinimumVersion != nil {
+			thisVersion := NewSksVersion(node.Version)
+			if thisVersion == nil || !thisVersion.IsAtLeast(policy.MinimumVersion) {
+				skip_this_age = true
+				count_servers_too_old += 1
+			}
+		}
+
+		if policy.ProxiesOnly && node.ViaHeader == "" {
+			server := strings.ToLower(strings.SplitN(node.ServerHeader, "/", 2)[0])
+			if _, ok := serverHeadersNative[server]; ok {
+				skip_this_nonproxy = true
+				count_servers_unwanted_server += 1
+			}
+		}
+
+		if policy.Countries.Initialized() {
+			var keep bool
+			for _, ip := range node.IpList {
+				geo, ok := persisted.IPCountryMap[ip]
+				if ok && policy.Countries.HasCountry(geo) {
+					keep = true
+				}
+			}
+			if !keep {
+				skip_this_country = true
+				count_servers_wrong_country += 1
+			}
+		}
+
+		answering := node.AnsweringIPs()
+		if len(answering) < len(node.IpList) {
+			count_ips_not_answering += len(node.IpList) - len(answering)
+			Statsf("dropping %d of %d IPs of server <%s> which failed their probe", len(node.IpList)-len(answering), len(node.IpList), name)
+		}
+		if len(answering) > 0 {
+			ips_one_per_server[answering[0]] = node.Keycount
+			for _, ip := range answering {
+				ips_all[ip] = node.Keycount
+				if probe, ok := node.IPStatus[ip]; ok {
+					ips_all[ip] = probe.Keycount
+				}
+				if skip_this_1010 {
+					ips_skip_1010.Insert(ip)
+				}
+				if skip_this_age {
+					ips_too_old.Insert(ip)
+				}
+				if skip_this_nonproxy {
+					ips_unwanted_server.Insert(ip)
+				}
+				if skip_this_country {
+					ips_wrong_country.Insert(ip)
+				}
+				if policy.LookupOK && !node.LookupOK(ip) {
+					ips_lookup_failed.Insert(ip)
+					skip_this_lookup = true
+				}
+			}
+		}
+		if skip_this_lookup {
+			count_servers_lookup_failed += 1
+		}
+
+	}
+	result.Considered = len(ips_all)
+	result.NotAnswering = count_ips_not_answering
+	stage("collected", 0, len(ips_all))
+
+	Statsf("%d IPs left out for not answering when probed", count_ips_not_answering)
+	cut = algorithm.Cut(&PoolInput{
+		PerServer:  ips_one_per_server,
+		All:        ips_all,
+		BucketSize: ps.BucketSize,
+		Jitter:     ps.Jitter,
+		Percentile: policy.Percentile,
+		Statsf:     Statsf,
+	})
+	if cut.Invalid != "" {
+		return invalid(cut.Invalid)
+	}
+	stage("bounds", 0, len(cut.InBounds))
+	reached = traceBounds
+
+	if cut.Center < float64(ps.SanityMin) {
+		Statsf("%s %f < %d", cut.CenterName, cut.Center, ps.SanityMin)
+		return invalid("broken_data")
+	}
+	if persisted.MeshAnomaly != "" {
+		Statsf("%s, now %d", persisted.MeshAnomaly, persisted.KeycountReference)
+		return invalid("mesh_keycount_drop")
+	}
+	threshold = cut.Threshold
+	if policy.Threshold > 0 {
+		Statsf("Overriding threshold from CGI parameter; %d -> %d", threshold, policy.Threshold)
+		threshold = policy.Threshold
+	}
+	result.Threshold = threshold
+	reached = traceThreshold
+
+	ips := make([]string, 0, len(cut.InBounds))
+	for ip, count := range cut.InBounds {
+		if count >= threshold {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	stage("threshold", 0, len(ips))
+	if len(ips) == 0 {
+		Statsf("No IPs above threshold %d", threshold)
+		return invalid("threshold_too_high")
+	}
+
+	filterOut := func(name, rationale string, eliminate sortedSet, eliminate_server_count int, candidates []string) []string {
+		alreadyDropped := newSortedSet()
+		for ip := range eliminate.Data() {
+			alreadyDropped.Insert(ip)
+		}
+		for _, ip := range candidates {
+			alreadyDropped.Remove(ip)
+		}
+		ips := make([]string, 0, len(candidates))
+		for _, ip := range candidates {
+			if !eliminate.Contains(ip) {
+				ips = append(ips, ip)
+			}
+		}
+		Statsf("dropping all %d servers %s, for %d possible IPs but %d of those already dropped",
+			eliminate_server_count, rationale, eliminate.Len(), alreadyDropped.Len())
+		stage(name, eliminate_server_count, len(ips))
+		return ips
+	}
+
+	ips = filterOut("skip_1010", "running version v1.0.10", ips_skip_1010, count_servers_1010, ips)
+	if len(ips) == 0 {
+		return invalid("No_servers_left_after_v1.0.10_filter")
+	}
+
+	if policy.MinimumVersion != nil {
+		ips = filterOut("minimum_version", fmt.Sprintf("running version < v%s", policy.MinimumVersion), ips_too_old, count_servers_too_old, ips)
+		if len(ips) == 0 {
+			return invalid(fmt.Sprintf("No_servers_left_after_minimum_version_filter_(v%s)", policy.MinimumVersion))
+		}
+	}
+
+	if policy.Countries.Initialized() {
+		ips = filterOut("countries", fmt.Sprintf("not in countries [%s]", policy.Countries), ips_wrong_country, count_servers_wrong_country, ips)
+		if len(ips) == 0 {
+			return invalid(fmt.Sprintf("No_servers_left_after_country_filter_[%s]", policy.Countries))
+		}
+	}
+
+	if policy.LookupOK {
+		ips = filterOut("lookup_ok", "failing canary key lookups", ips_lookup_failed, count_servers_lookup_failed, ips)
+		if len(ips) == 0 {
+			return invalid("No_servers_left_after_lookup_filter")
+		}
+	}
+
+	if policy.ProxiesOnly {
+		ips = filterOut("proxies", "not behind a web-proxy", ips_unwanted_server, count_servers_unwanted_server, ips)
+		if len(ips) == 0 {
+			return invalid("No_servers_left_after_proxies_filter")
+		}
+	}
+
+	result.IPs = ips
+	if traceAll != nil {
+		traceAll("")
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fixtureSelector works on the 2012 snapshot, whose keycounts predate the
+// current sanity minimum.
+func fixtureSelector(t *testing.T) (*PoolSelector, map[string]*SksNode) {
+	persisted := loadTestPersisted(t)
+	ps := NewPoolSelector(persisted)
+	ps.SanityMin = 3000000
+	ps.Jitter = 800
+	owners := make(map[string]*SksNode)
+	for _, node := range persisted.HostMap {
+		for _, ip := range node.IpList {
+			owners[ip] = node
+		}
+	}
+	return ps, owners
+}
+
+// fixturePersisted makes the snapshot the current scan for the ip-valid
+// handler, with the sanity minimum lowered to suit it; call restore when done.
+func fixturePersisted(t *testing.T) (persisted *PersistedHostInfo, restore func()) {
+	persisted = loadTestPersisted(t)
+	restorePersisted := withCurrentPersisted(persisted)
+	saneMin := *flKeysSanityMin
+	*flKeysSanityMin = 3000000
+	return persisted, func() {
+		*flKeysSanityMin = saneMin
+		restorePersisted()
+	}
+}
+
+// withFixturePersisted is fixturePersisted for tests which only need the
+// handler: defer withFixturePersisted(t)()
+func withFixturePersisted(t *testing.T) (restore func()) {
+	_, restore = fixturePersisted(t)
+	return restore
+}
+
+func TestPoolSelectorFixture(t *testing.T) {
+	ps, owners := fixtureSelector(t)
+	result := ps.Select(SelectionPolicy{})
+	if result.Status != SelectionComplete {
+		t.Fatalf("expected COMPLETE, got %s %s", result.Status, result.Reason)
+	}
+	if result.Threshold != 3168042 || len(result.IPs) != 112 || result.Considered != 132 {
+		t.Errorf("snapshot changed: threshold=%d ips=%d considered=%d, expected 3168042/112/132",
+			result.Threshold, len(result.IPs), result.Considered)
+	}
+	for _, ip := range result.IPs {
+		node := owners[ip]
+		if node.Keycount < result.Threshold {
+			t.Errorf("%s (%s) has %d keys, below threshold", ip, node.Hostname, node.Keycount)
+		}
+		if node.Version == "1.0.10" {
+			t.Errorf("%s (%s) runs 1.0.10", ip, node.Hostname)
+		}
+	}
+	stages := []string{"collected", "bounds", "threshold", "skip_1010"}
+	if len(result.Stages) != len(stages) {
+		t.Fatalf("expected stages %v, got %v", stages, result.Stages)
+	}
+	for i, name := range stages {
+		if result.Stages[i].Stage != name {
+			t.Errorf("stage %d: expected %s, got %s", i, name, result.Stages[i].Stage)
+		}
+	}
+	if last := result.Stages[len(result.Stages)-1]; last.IPs != len(result.IPs) || last.Servers != 2 {
+		t.Errorf("skip_1010 stage: %+v", last)
+	}
+
+	again := ps.Select(SelectionPolicy{})
+	for i := range result.IPs {
+		if result.IPs[i] != again.IPs[i] {
+			t.Fatal("selection is not deterministic")
+		}
+	}
+
+	minimum := NewSksVersion("1.1.3")
+	result = ps.Select(SelectionPolicy{MinimumVersion: minimum})
+	if len(result.IPs) != 73 {
+		t.Errorf("minimum_version 1.1.3: expected 73 IPs, got %d", len(result.IPs))
+	}
+	for _, ip := range result.IPs {
+		if v := NewSksVersion(owners[ip].Version); v == nil || !v.IsAtLeast(minimum) {
+			t.Errorf("%s runs %s, below 1.1.3", ip, owners[ip].Version)
+		}
+	}
+
+	result = ps.Select(SelectionPolicy{Threshold: 2900000})
+	if result.Threshold != 2900000 || len(result.IPs) != 112 {
+		t.Errorf("threshold override: got %d with %d IPs", result.Threshold, len(result.IPs))
+	}
+}
+
+func TestPoolSelectorInvalid(t *testing.T) {
+	if r := NewPoolSelector(nil).Select(SelectionPolicy{}); r.Status != SelectionInvalid || r.Reason != "first_scan" {
+		t.Errorf("no data: got %s %s", r.Status, r.Reason)
+	}
+
+	ps, _ := fixtureSelector(t)
+	// The snapshot has no locations, so nothing is in any country.
+	r := ps.Select(SelectionPolicy{Countries: NewCountrySet("DE")})
+	if r.Status != SelectionInvalid || r.Reason != "No_servers_left_after_country_filter_[DE]" || r.IPs != nil {
+		t.Errorf("countries: got %s %s %v", r.Status, r.Reason, r.IPs)
+	}
+
+	ps.SanityMin = *flKeysSanityMin
+	if r := ps.Select(SelectionPolicy{}); r.Reason != "broken_data" {
+		t.Errorf("2012 keycounts under today's sanity minimum: got %s %s", r.Status, r.Reason)
+	}
+
+	ps, _ = fixtureSelector(t)
+	ps.persisted.MeshAnomaly = "mesh reference keycount fell 20.0% since the previous scan"
+	if r := ps.Select(SelectionPolicy{}); r.Reason != "mesh_keycount_drop" {
+		t.Errorf("mesh anomaly: got %s %s", r.Status, r.Reason)
+	}
+}
+
+func TestIpValidJson(t *testing.T) {
+	defer withFixturePersisted(t)()
+
+	for _, tc := range []struct {
+		query  string
+		status string
+		ips    int
+	}{
+		{"json&stats", SelectionComplete, 112},
+		{"json&countries=DE", SelectionInvalid, 0},
+	} {
+		rec := httptest.NewRecorder()
+		apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?"+tc.query, nil))
+		var response struct {
+			Stats  []string
+			Status map[string]interface{}
+			IPs    []string
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: bad JSON: %s\n%s", tc.query, err, rec.Body.String())
+		}
+		if response.Status["status"] != tc.status || len(response.IPs) != tc.ips {
+			t.Errorf("%s: got status %v with %d IPs", tc.query, response.Status["status"], len(response.IPs))
+		}
+		if int(response.Status["count"].(float64)) != tc.ips {
+			t.Errorf("%s: count %v, expected %d", tc.query, response.Status["count"], tc.ips)
+		}
+	}
+}
+
+func TestIpValidLookupOK(t *testing.T) {
+	defer withFixturePersisted(t)()
+	savedCanaries := *flCanaryKeys
+	defer func() { *flCanaryKeys = savedCanaries }()
+
+	for _, tc := range []struct {
+		canaries string
+		status   int
+	}{
+		{"", http.StatusBadRequest},
+		{"0123456789ABCDEF0123456789ABCDEF01234567", http.StatusOK},
+	} {
+		*flCanaryKeys = tc.canaries
+		rec := httptest.NewRecorder()
+		apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?json&lookup_ok=1", nil))
+		if rec.Code != tc.status {
+			t.Errorf("-canary-keys %q: status %d, expected %d: %s", tc.canaries, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+}
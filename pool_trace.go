@@ -0,0 +1,190 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// "Why isn't my server in the pool?"  The trace follows each candidate IP
+// through the same stages as the selection, and says where it fell out.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How far a selection got before it finished or gave up.
+const (
+	traceCollected = iota
+	traceBounds
+	traceThreshold
+)
+
+type TraceStep struct {
+	Stage  string `json:"stage"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+type IPTrace struct {
+	IP       string       `json:"ip"`
+	Hostname string       `json:"hostname"`
+	Keycount int          `json:"keycount"`
+	Steps    []*TraceStep `json:"steps"`
+	Selected bool         `json:"selected"`
+	Verdict  string       `json:"verdict"`
+}
+
+func (t *IPTrace) step(stage string, passed bool, format string, v ...interface{}) bool {
+	t.Steps = append(t.Steps, &TraceStep{Stage: stage, Passed: passed, Detail: fmt.Sprintf(format, v...)})
+	return passed
+}
+
+type selectionTrace struct {
+	policy     *SelectionPolicy
+	persisted  *PersistedHostInfo
+	cut        *PoolCut
+	threshold  int
+	reached    int
+	invalid    string
+	selected   []string
+	eliminated map[string]sortedSet
+}
+
+func (st *selectionTrace) build() []*IPTrace {
+	selected := make(map[string]bool, len(st.selected))
+	for _, ip := range st.selected {
+		selected[ip] = true
+	}
+	traces := make([]*IPTrace, 0, len(st.persisted.HostMap)*2)
+	for _, name := range st.persisted.Sorted {
+		node := st.persisted.HostMap[name]
+		answering := make(map[string]bool, len(node.IpList))
+		for _, ip := range node.AnsweringIPs() {
+			answering[ip] = true
+		}
+		for _, ip := range node.IpList {
+			t := &IPTrace{IP: ip, Hostname: name, Keycount: node.Keycount, Selected: selected[ip]}
+			if probe, ok := node.IPStatus[ip]; ok && answering[ip] {
+				t.Keycount = probe.Keycount
+			}
+			st.follow(t, node, answering[ip])
+			t.Verdict = st.verdict(t)
+			traces = append(traces, t)
+		}
+	}
+	return traces
+}
+
+// follow records the stages the IP went through, stopping at the first it
+// failed which kept it out of the statistics; the later filters are all
+// reported, since an operator will want to know of every problem at once.
+func (st *selectionTrace) follow(t *IPTrace, node *SksNode, answering bool) {
+	if !t.step("keycount", node.Keycount > 1, "server reports %d keys", node.Keycount) {
+		return
+	}
+	if answering {
+		t.step("probe", true, "answered")
+	} else if !t.step("probe", false, "failed its probe: %s", node.IPStatus[t.IP]) {
+		return
+	}
+	if st.reached < traceBounds {
+		return
+	}
+	_, inBounds := st.cut.InBounds[t.IP]
+	relation := "within"
+	if !inBounds {
+		relation = "outside"
+	}
+	if !t.step("bounds", inBounds, "keycount %d %s plausible bounds [%d, %d]",
+		t.Keycount, relation, st.cut.BoundsMin, st.cut.BoundsMax) {
+		return
+	}
+	if st.reached < traceThreshold {
+		return
+	}
+	if t.Keycount >= st.threshold {
+		t.step("threshold", true, "keycount %d >= threshold %d", t.Keycount, st.threshold)
+	} else {
+		t.step("threshold", false, "keycount %d < threshold %d, %d short", t.Keycount, st.threshold, st.threshold-t.Keycount)
+	}
+
+	t.step("skip_1010", !st.eliminated["skip_1010"].Contains(t.IP), "version %q", node.Version)
+	if st.policy.MinimumVersion != nil {
+		t.step("minimum_version", !st.eliminated["minimum_version"].Contains(t.IP),
+			"version %q, want at least %s", node.Version, st.policy.MinimumVersion)
+	}
+	if st.policy.Countries.Initialized() {
+		located := make([]string, 0, len(node.IpList))
+		for _, ip := range node.IpList {
+			if geo, ok := st.persisted.IPCountryMap[ip]; ok {
+				located = append(located, geo)
+			}
+		}
+		where := "unknown"
+		if len(located) > 0 {
+			where = strings.Join(located, ",")
+		}
+		t.step("countries", !st.eliminated["countries"].Contains(t.IP),
+			"server located in %s, want [%s]", where, st.policy.Countries)
+	}
+	if st.policy.LookupOK {
+		ok := !st.eliminated["lookup_ok"].Contains(t.IP)
+		detail := "canary key lookups succeeded"
+		if !ok {
+			detail = "canary key lookups failed"
+		}
+		t.step("lookup_ok", ok, "%s", detail)
+	}
+	if st.policy.ProxiesOnly {
+		t.step("proxies", !st.eliminated["proxies"].Contains(t.IP),
+			"server header %q, via %q", node.ServerHeader, node.ViaHeader)
+	}
+}
+
+func (st *selectionTrace) verdict(t *IPTrace) string {
+	if t.Selected {
+		return "selected"
+	}
+	for _, s := range t.Steps {
+		if !s.Passed {
+			return fmt.Sprintf("dropped at %s: %s", s.Stage, s.Detail)
+		}
+	}
+	if st.invalid != "" {
+		return "pool invalid: " + st.invalid
+	}
+	return "not selected"
+}
+
+// ExplainTrace picks the traces for one server, by any of its names, or
+// for one IP.
+func ExplainTrace(persisted *PersistedHostInfo, traces []*IPTrace, query string) []*IPTrace {
+	query = strings.ToLower(strings.TrimSpace(query))
+	hostname := query
+	if persisted != nil {
+		if canon, ok := persisted.AliasMap[query]; ok {
+			hostname = canon
+		}
+	}
+	matched := make([]*IPTrace, 0, 2)
+	for _, t := range traces {
+		if t.IP == query || strings.EqualFold(t.Hostname, hostname) {
+			matched = append(matched, t)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].IP < matched[j].IP })
+	return matched
+}
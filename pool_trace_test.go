@@ -0,0 +1,122 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSelectionTrace(t *testing.T) {
+	ps, owners := fixtureSelector(t)
+	result := ps.Select(SelectionPolicy{Trace: true})
+	if result.Status != SelectionComplete {
+		t.Fatalf("%s %s", result.Status, result.Reason)
+	}
+
+	selected := 0
+	var old, low *IPTrace
+	for _, tr := range result.Trace {
+		if tr.Selected {
+			selected++
+			if tr.Verdict != "selected" {
+				t.Errorf("%s selected with verdict %q", tr.IP, tr.Verdict)
+			}
+			continue
+		}
+		if owners[tr.IP].Version == "1.0.10" && old == nil {
+			old = tr
+		}
+		if tr.Keycount > 1 && tr.Keycount < result.Threshold && low == nil {
+			low = tr
+		}
+	}
+	if selected != len(result.IPs) {
+		t.Errorf("%d traces selected, but %d IPs", selected, len(result.IPs))
+	}
+	if old == nil {
+		t.Fatalf("expected a 1.0.10 server among the %d traces", len(result.Trace))
+	}
+	if !strings.HasPrefix(old.Verdict, "dropped at skip_1010") {
+		t.Errorf("expected a 1.0.10 server dropped by skip_1010, got %+v", old)
+	}
+	if low == nil || !(strings.HasPrefix(low.Verdict, "dropped at bounds") || strings.HasPrefix(low.Verdict, "dropped at threshold")) {
+		t.Errorf("expected a lagging server dropped on its keycount, got %+v", low)
+	}
+
+	explained := ExplainTrace(ps.persisted, result.Trace, old.Hostname)
+	if len(explained) != len(owners[old.IP].IpList) {
+		t.Errorf("explain %s: expected its %d IPs, got %d", old.Hostname, len(owners[old.IP].IpList), len(explained))
+	}
+	if got := ExplainTrace(ps.persisted, result.Trace, old.IP); len(got) != 1 || got[0] != old {
+		t.Errorf("explain %s: got %v", old.IP, got)
+	}
+	if got := ExplainTrace(ps.persisted, result.Trace, "nowhere.example.org"); len(got) != 0 {
+		t.Errorf("explain unknown host: got %v", got)
+	}
+
+	if r := ps.Select(SelectionPolicy{}); r.Trace != nil {
+		t.Error("trace recorded without being asked for")
+	}
+
+	result = ps.Select(SelectionPolicy{Trace: true, Countries: NewCountrySet("DE")})
+	for _, tr := range result.Trace {
+		if tr.Verdict == "selected" {
+			t.Errorf("%s selected in an invalid pool", tr.IP)
+		}
+		if strings.HasPrefix(tr.Verdict, "dropped at countries") {
+			return
+		}
+	}
+	t.Error("no IP was dropped at countries in a pool left empty by the country filter")
+}
+
+func TestIpValidExplain(t *testing.T) {
+	persisted, restore := fixturePersisted(t)
+	defer restore()
+
+	host := persisted.Sorted[0]
+	rec := httptest.NewRecorder()
+	apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?explain="+host, nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "EXPLAIN: ") || !strings.Contains(body, "<"+host+"> verdict: ") {
+		t.Errorf("no explanation for %s in:\n%s", host, body)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "EXPLAIN: ") && !strings.Contains(line, "<"+host+">") {
+			t.Errorf("explanation of another host: %s", line)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?explain=nowhere.example.org", nil))
+	if !strings.Contains(rec.Body.String(), "EXPLAIN: nowhere.example.org is not a candidate") {
+		t.Errorf("unknown host not reported:\n%s", rec.Body.String())
+	}
+}
+
+func TestIpValidExplainFirstScan(t *testing.T) {
+	defer withCurrentPersisted(nil)()
+
+	rec := httptest.NewRecorder()
+	apiIpValidPage(rec, httptest.NewRequest("GET", "/sks-peers/ip-valid?explain=sks.spodhuis.org", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "EXPLAIN: sks.spodhuis.org cannot be explained: first_scan") || strings.Contains(body, "unknown to the mesh") {
+		t.Errorf("explain before the first scan gave:\n%s", body)
+	}
+}
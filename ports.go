@@ -0,0 +1,166 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Servers don't all use the default ports.  SKS reports "HTTP port" and
+// "Recon port" in its settings, Hockeypuck reports httpAddr and reconAddr,
+// and every gossip peer list gives the recon port which that peer uses to
+// reach each of its peers.  By convention, the HKP port is one above recon.
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// portFromSetting handles both "11371" and ":11371" or "[::]:11371".
+func portFromSetting(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if strings.Contains(value, ":") {
+		_, p, err := net.SplitHostPort(value)
+		if err != nil {
+			return 0
+		}
+		value = p
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0
+	}
+	return port
+}
+
+// advertisedPorts gives what the server says of itself; 0 for unknown.
+func advertisedPorts(settings map[string]string) (hkp, recon int) {
+	for _, key := range []string{"HTTP port", "HttpAddr"} {
+		if hkp = portFromSetting(settings[key]); hkp != 0 {
+			break
+		}
+	}
+	for _, key := range []string{"Recon port", "ReconAddr"} {
+		if recon = portFromSetting(settings[key]); recon != 0 {
+			break
+		}
+	}
+	return
+}
+
+// hkpPortForRecon applies the convention, leaving the defaults as configured.
+func hkpPortForRecon(recon int) int {
+	if recon == 0 || recon == *flSksPortRecon {
+		return *flSksPortHkp
+	}
+	return recon + 1
+}
+
+// noteReconHints records the recon ports which a host's gossip peers list.
+func (spider *Spider) noteReconHints(origin string, peers map[string]string) {
+	for peer, portText := range peers {
+		port := portFromSetting(portText)
+		if port == 0 {
+			continue
+		}
+		if spider.reconHints[peer] == nil {
+			spider.reconHints[peer] = make(map[string]int)
+		}
+		spider.reconHints[peer][origin] = port
+	}
+}
+
+// hkpPortFor picks the port to fetch stats from, going by what most of the
+// peers seen so far think the recon port is.  Ties go to the default recon
+// port, and otherwise to the lowest port, so the answer is repeatable.
+func (spider *Spider) hkpPortFor(hostname string) int {
+	votes := make(map[int]int)
+	for _, port := range spider.reconHints[hostname] {
+		votes[port]++
+	}
+	best, bestVotes := 0, 0
+	for port, count := range votes {
+		switch {
+		case count > bestVotes:
+		case count < bestVotes:
+			continue
+		case best == *flSksPortRecon:
+			continue
+		case port != *flSksPortRecon && port > best:
+			continue
+		}
+		best, bestVotes = port, count
+	}
+	return hkpPortForRecon(best)
+}
+
+// peerReconPortViews maps each canonical host to the recon ports its peers
+// use to reach it, and which peers use each.
+func peerReconPortViews(hostMap HostMap, aliasMap AliasMap) map[string]map[int][]string {
+	views := make(map[string]map[int][]string, len(hostMap))
+	for origin, node := range hostMap {
+		for peer, portText := range node.GossipPeers {
+			canon, ok := aliasMap[peer]
+			port := portFromSetting(portText)
+			if !ok || port == 0 {
+				continue
+			}
+			if views[canon] == nil {
+				views[canon] = make(map[int][]string)
+			}
+			views[canon][port] = append(views[canon][port], origin)
+		}
+	}
+	return views
+}
+
+// CheckAdvertisedPorts compares what each server says of its ports with
+// where we found it and with what its peers use, filling in PortProblems.
+func CheckAdvertisedPorts(hostMap HostMap, aliasMap AliasMap) {
+	views := peerReconPortViews(hostMap, aliasMap)
+
+	for hostname, node := range hostMap {
+		node.PortProblems = nil
+		if node.AdvertisedHkpPort != 0 && node.Port != 0 && node.AdvertisedHkpPort != node.Port {
+			problem := fmt.Sprintf("advertises HTTP port %d, but stats were fetched on port %d", node.AdvertisedHkpPort, node.Port)
+			if node.ViaHeader != "" {
+				problem += " (behind a proxy, so may be intended)"
+			}
+			node.PortProblems = append(node.PortProblems, problem)
+		}
+		if node.AdvertisedReconPort == 0 {
+			continue
+		}
+		ports := make([]int, 0, len(views[hostname]))
+		for port := range views[hostname] {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			if port == node.AdvertisedReconPort {
+				continue
+			}
+			origins := views[hostname][port]
+			HostSort(origins)
+			node.PortProblems = append(node.PortProblems, fmt.Sprintf(
+				"advertises recon port %d, but %d peers use port %d: %s",
+				node.AdvertisedReconPort, len(origins), port, strings.Join(origins, ", ")))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAdvertisedPorts(t *testing.T) {
+	for _, tc := range []struct {
+		settings   map[string]string
+		hkp, recon int
+	}{
+		{map[string]string{"HTTP port": "11371", "Recon port": "11370"}, 11371, 11370},
+		{map[string]string{"HttpAddr": ":11371", "ReconAddr": "[::]:11380"}, 11371, 11380},
+		{map[string]string{"HTTP port": "bogus"}, 0, 0},
+		{nil, 0, 0},
+	} {
+		if hkp, recon := advertisedPorts(tc.settings); hkp != tc.hkp || recon != tc.recon {
+			t.Errorf("advertisedPorts(%v) = %d, %d; expected %d, %d", tc.settings, hkp, recon, tc.hkp, tc.recon)
+		}
+	}
+
+	spider := &Spider{reconHints: make(map[string]map[string]int)}
+	if port := spider.hkpPortFor("keys.example.org"); port != *flSksPortHkp {
+		t.Fatalf("Host without hints got port %d", port)
+	}
+	spider.noteReconHints("a.example.org", map[string]string{"keys.example.org": "11380"})
+	spider.noteReconHints("b.example.org", map[string]string{"keys.example.org": "11380"})
+	spider.noteReconHints("c.example.org", map[string]string{"keys.example.org": "11370"})
+	if port := spider.hkpPortFor("keys.example.org"); port != 11381 {
+		t.Fatalf("Expected HKP port 11381 from majority hint, got %d", port)
+	}
+
+	// Ties between non-default ports go to the lowest, whatever the map order.
+	spider.noteReconHints("d.example.org", map[string]string{"keys.example.org": "11390"})
+	spider.noteReconHints("e.example.org", map[string]string{"keys.example.org": "11390"})
+	spider.noteReconHints("c.example.org", map[string]string{"keys.example.org": "11385"})
+	spider.noteReconHints("f.example.org", map[string]string{"keys.example.org": "11385"})
+	for i := 0; i < 20; i++ {
+		if port := spider.hkpPortFor("keys.example.org"); port != 11381 {
+			t.Fatalf("Expected HKP port 11381 from lowest tied hint, got %d", port)
+		}
+	}
+	// ... unless the default recon port is among them.
+	spider.noteReconHints("g.example.org", map[string]string{"keys.example.org": "11370"})
+	spider.noteReconHints("h.example.org", map[string]string{"keys.example.org": "11370"})
+	for i := 0; i < 20; i++ {
+		if port := spider.hkpPortFor("keys.example.org"); port != *flSksPortHkp {
+			t.Fatalf("Expected default HKP port from tied default recon hint, got %d", port)
+		}
+	}
+}
+
+func TestCheckAdvertisedPorts(t *testing.T) {
+	hostMap := HostMap{
+		"keys.example.org": {
+			Hostname: "keys.example.org", Port: 11371,
+			AdvertisedHkpPort: 11372, AdvertisedReconPort: 11370,
+		},
+		"a.example.org": {
+			Hostname: "a.example.org", Port: 11371, AdvertisedReconPort: 11370,
+			GossipPeers: map[string]string{"keys.example.org": "11380"},
+		},
+		"b.example.org": {
+			Hostname: "b.example.org", Port: 11371, AdvertisedReconPort: 11370,
+			GossipPeers: map[string]string{"keys.example.org": "11370", "a.example.org": "11370"},
+		},
+	}
+	CheckAdvertisedPorts(hostMap, GetAliasMapForHostmap(hostMap))
+
+	problems := hostMap["keys.example.org"].PortProblems
+	if len(problems) != 2 || !strings.Contains(problems[0], "HTTP port 11372") || !strings.Contains(problems[1], "port 11380: a.example.org") {
+		t.Fatalf("Unexpected port problems: %q", problems)
+	}
+	if problems := hostMap["a.example.org"].PortProblems; len(problems) != 0 {
+		t.Fatalf("Consistent host has port problems: %q", problems)
+	}
+}
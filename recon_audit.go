@@ -0,0 +1,219 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Recon between two peers only works if they agree on the filters applied to
+// keys, and on the prefix-tree parameters; otherwise the config exchange
+// fails and they silently never sync.  The stats page gives some of these
+// settings, and our recon probe gets the rest from the server itself.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ReconSettings struct {
+	Filters    []string `json:"filters,omitempty"` // sorted; nil if unknown
+	Bitquantum string   `json:"bitquantum,omitempty"`
+	Mbar       string   `json:"mbar,omitempty"`
+	ReconPort  int      `json:"recon_port,omitempty"`
+}
+
+func splitFilters(value string) []string {
+	var filters []string
+	for _, f := range strings.Split(value, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			filters = append(filters, f)
+		}
+	}
+	sort.Strings(filters)
+	if filters == nil {
+		filters = []string{}
+	}
+	return filters
+}
+
+// reconSettingsOf prefers what the server told us over recon, falling back
+// to its stats page.
+func reconSettingsOf(node *SksNode) *ReconSettings {
+	rs := &ReconSettings{ReconPort: node.AdvertisedReconPort}
+	if value, ok := node.Settings["Filters"]; ok {
+		rs.Filters = splitFilters(value)
+	}
+	if node.Recon.OK() {
+		if value, ok := node.Recon.Config["filters"]; ok {
+			rs.Filters = splitFilters(value)
+		}
+		rs.Bitquantum = node.Recon.Config["bitquantum"]
+		rs.Mbar = node.Recon.Config["mbar"]
+	}
+	return rs
+}
+
+type PeeringIssue struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Mutual   bool     `json:"mutual"`
+	Problems []string `json:"problems"`
+}
+
+// peeringProblems compares what matters for recon between two servers;
+// unknown settings are not held against either.
+func peeringProblems(from, to string, fromNode, toNode *SksNode) []string {
+	return append(settingsProblems(from, to, fromNode, toNode), directedProblems(from, to, fromNode, toNode)...)
+}
+
+// settingsProblems are the mismatches in recon settings, which are the same
+// whichever way round the link is looked at.
+func settingsProblems(from, to string, fromNode, toNode *SksNode) []string {
+	var problems []string
+	a, b := reconSettingsOf(fromNode), reconSettingsOf(toNode)
+	if a.Filters != nil && b.Filters != nil && strings.Join(a.Filters, ",") != strings.Join(b.Filters, ",") {
+		problems = append(problems, fmt.Sprintf("filters differ: %s has %q, %s has %q",
+			from, strings.Join(a.Filters, ","), to, strings.Join(b.Filters, ",")))
+	}
+	if a.Bitquantum != "" && b.Bitquantum != "" && a.Bitquantum != b.Bitquantum {
+		problems = append(problems, fmt.Sprintf("bitquantum differs: %s vs %s", a.Bitquantum, b.Bitquantum))
+	}
+	if a.Mbar != "" && b.Mbar != "" && a.Mbar != b.Mbar {
+		problems = append(problems, fmt.Sprintf("mbar differs: %s vs %s", a.Mbar, b.Mbar))
+	}
+	return problems
+}
+
+// directedProblems are those of from reaching to: the port it uses, and
+// whether the far end's recon port can be reached at all.  A port which
+// accepts the connection but refuses us the config exchange is normal, as we
+// are not a configured peer; its settings are just unknown.
+func directedProblems(from, to string, fromNode, toNode *SksNode) []string {
+	var problems []string
+	b := reconSettingsOf(toNode)
+	for alias, portText := range fromNode.GossipPeers {
+		if !strings.EqualFold(alias, to) && !stringInSliceFold(alias, toNode.Aliases) {
+			continue
+		}
+		if port := portFromSetting(portText); port != 0 && b.ReconPort != 0 && port != b.ReconPort {
+			problems = append(problems, fmt.Sprintf("%s uses recon port %d, %s has %d", from, port, to, b.ReconPort))
+		}
+	}
+	if toNode.Recon != nil && !toNode.Recon.Reachable {
+		problems = append(problems, fmt.Sprintf("recon port of %s failed our probe: %s", to, toNode.Recon))
+	}
+	return problems
+}
+
+func stringInSliceFold(s string, list []string) bool {
+	for _, item := range list {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditReconCompatibility checks every gossip link between servers we have
+// stats for, returning those with problems.  A settings mismatch between
+// mutual peers is reported once, on the link from the first of the pair.
+func AuditReconCompatibility(persisted *PersistedHostInfo) []*PeeringIssue {
+	issues := []*PeeringIssue{}
+	for _, from := range persisted.Sorted {
+		fromNode := persisted.HostMap[from]
+		if fromNode.AnalyzeError != "" {
+			continue
+		}
+		lowerFrom := strings.ToLower(from)
+		for _, to := range persisted.Graph.outboundList(lowerFrom) {
+			withSettings := !persisted.Graph.ExistsLink(to, lowerFrom) || hostCompare(lowerFrom, to) < 0
+			if issue := persisted.peeringIssue(from, to, withSettings); issue != nil {
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues
+}
+
+// peeringIssuesOf checks the gossip links out from one server.
+func peeringIssuesOf(persisted *PersistedHostInfo, from string) []*PeeringIssue {
+	var issues []*PeeringIssue
+	lowerFrom := strings.ToLower(from)
+	for _, to := range persisted.Graph.outboundList(lowerFrom) {
+		if issue := persisted.peeringIssue(from, to, true); issue != nil {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// peeringIssue checks the one gossip link, returning nil if it looks fine or
+// if we don't have data for both ends.  Without withSettings, only the
+// problems particular to this direction are looked for.
+func (persisted *PersistedHostInfo) peeringIssue(from, to string, withSettings bool) *PeeringIssue {
+	fromCanon, okFrom := persisted.Graph.Canonical(from)
+	toCanon, okTo := persisted.Graph.Canonical(to)
+	if !okFrom || !okTo {
+		return nil
+	}
+	fromNode, toNode := persisted.HostMap[fromCanon], persisted.HostMap[toCanon]
+	if fromNode == nil || toNode == nil || fromNode.AnalyzeError != "" || toNode.AnalyzeError != "" {
+		return nil
+	}
+	var problems []string
+	if withSettings {
+		problems = peeringProblems(fromCanon, toCanon, fromNode, toNode)
+	} else {
+		problems = directedProblems(fromCanon, toCanon, fromNode, toNode)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PeeringIssue{
+		From:     fromCanon,
+		To:       toCanon,
+		Mutual:   persisted.Graph.ExistsLink(toCanon, fromCanon),
+		Problems: problems,
+	}
+}
+
+// linkProblems gathers the recon problems of the gossip links between peer
+// and other, in whichever directions they exist; for a mutual link, the
+// settings mismatches are only given once.
+func (persisted *PersistedHostInfo) linkProblems(peer, other string, out, in bool) []string {
+	var problems []string
+	if issue := persisted.peeringIssue(peer, other, true); out && issue != nil {
+		problems = append(problems, issue.Problems...)
+	}
+	if issue := persisted.peeringIssue(other, peer, !out); in && issue != nil {
+		problems = append(problems, issue.Problems...)
+	}
+	return problems
+}
+
+// String summarises the recon settings for display.
+func (rs *ReconSettings) String() string {
+	filters := "unknown"
+	if rs.Filters != nil {
+		filters = strings.Join(rs.Filters, ",")
+	}
+	unknown := func(s string) string {
+		if s == "" {
+			return "?"
+		}
+		return s
+	}
+	return fmt.Sprintf("filters %s, bitquantum %s, mbar %s", filters, unknown(rs.Bitquantum), unknown(rs.Mbar))
+}
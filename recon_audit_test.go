@@ -0,0 +1,146 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeeringProblems(t *testing.T) {
+	a := &SksNode{
+		Hostname:    "a.example.org",
+		GossipPeers: map[string]string{"b.example.org": "11370"},
+		Settings:    map[string]string{"Filters": "yminsky.merge, yminsky.dedup"},
+		Recon:       &ReconProbe{Reachable: true, Config: map[string]string{"bitquantum": "2", "mbar": "5"}},
+	}
+	b := &SksNode{
+		Hostname:            "b.example.org",
+		AdvertisedReconPort: 11370,
+		Recon:               &ReconProbe{Reachable: true, Config: map[string]string{"bitquantum": "2", "mbar": "5", "filters": "yminsky.dedup,yminsky.merge"}},
+	}
+	if problems := peeringProblems("a.example.org", "b.example.org", a, b); len(problems) != 0 {
+		t.Errorf("compatible peers flagged: %v", problems)
+	}
+
+	b.Recon.Config["filters"] = "yminsky.dedup"
+	b.Recon.Config["mbar"] = "6"
+	b.AdvertisedReconPort = 11371
+	problems := peeringProblems("a.example.org", "b.example.org", a, b)
+	want := []string{"filters differ", "mbar differs", "uses recon port 11370"}
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), problems)
+	}
+	for i := range want {
+		if !strings.Contains(problems[i], want[i]) {
+			t.Errorf("problem %d: expected %q in %q", i, want[i], problems[i])
+		}
+	}
+
+	b.Recon = &ReconProbe{Port: 11371, Error: "connection refused"}
+	problems = peeringProblems("a.example.org", "b.example.org", a, b)
+	if len(problems) != 2 || !strings.Contains(problems[1], "failed our probe") {
+		t.Errorf("expected the port mismatch and failed probe, got %v", problems)
+	}
+
+	// Not being a configured peer, we are normally refused the config
+	// exchange; that leaves b's settings unknown, and is no problem.
+	b.Recon = &ReconProbe{Port: 11371, Reachable: true, Refused: true, Error: "EOF"}
+	problems = peeringProblems("a.example.org", "b.example.org", a, b)
+	if len(problems) != 1 || !strings.Contains(problems[0], "uses recon port 11370") {
+		t.Errorf("expected only the port mismatch with a refused probe, got %v", problems)
+	}
+}
+
+func TestAuditReconCompatibility(t *testing.T) {
+	persisted := loadTestPersisted(t)
+	baseline := len(AuditReconCompatibility(persisted))
+
+	var from, to string
+	for _, name := range persisted.Sorted {
+		if persisted.HostMap[name].AnalyzeError != "" {
+			continue
+		}
+		for _, peer := range persisted.Graph.MutualPeersOf(strings.ToLower(name)) {
+			canon, ok := persisted.Graph.Canonical(peer)
+			if ok && canon != name && persisted.HostMap[canon] != nil && persisted.HostMap[canon].AnalyzeError == "" {
+				from, to = name, canon
+				break
+			}
+		}
+		if from != "" {
+			break
+		}
+	}
+	if from == "" {
+		t.Fatal("no mutually peered servers in test data")
+	}
+
+	persisted.HostMap[from].Recon = &ReconProbe{Reachable: true, Config: map[string]string{"filters": "yminsky.dedup,yminsky.merge"}}
+	persisted.HostMap[to].Recon = &ReconProbe{Reachable: true, Config: map[string]string{"filters": "yminsky.dedup"}}
+
+	// The mismatch is one problem for the pair, not one per direction.
+	issues := AuditReconCompatibility(persisted)
+	if len(issues) != baseline+1 {
+		t.Errorf("expected %s <-> %s flagged once on top of %d, got %d issues", from, to, baseline, len(issues))
+	}
+	filterIssues := 0
+	for _, issue := range issues {
+		if strings.Contains(strings.Join(issue.Problems, "\n"), "filters differ") {
+			filterIssues++
+			if issue.From != from || issue.To != to || !issue.Mutual {
+				t.Errorf("expected the mutual %s -> %s to carry the mismatch, got %+v", from, to, issue)
+			}
+		}
+	}
+	if filterIssues != 1 {
+		t.Errorf("filter mismatch reported %d times", filterIssues)
+	}
+	found := false
+	for _, issue := range peeringIssuesOf(persisted, from) {
+		if issue.To == to {
+			found = true
+			if !issue.Mutual {
+				t.Errorf("%s -> %s should be mutual", from, to)
+			}
+			if !strings.Contains(strings.Join(issue.Problems, "\n"), "filters differ") {
+				t.Errorf("%s -> %s: expected filter mismatch, got %v", from, to, issue.Problems)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no issue reported for %s -> %s", from, to)
+	}
+
+	// The peer-info page shows a mutual link's settings mismatch only once,
+	// but the far end's failed probe is still its own problem.
+	for _, tc := range []struct {
+		out, in bool
+		filters int
+	}{{true, true, 1}, {true, false, 1}, {false, true, 1}, {false, false, 0}} {
+		problems := persisted.linkProblems(from, to, tc.out, tc.in)
+		if n := strings.Count(strings.Join(problems, "\n"), "filters differ"); n != tc.filters {
+			t.Errorf("out=%v in=%v: filter mismatch given %d times: %v", tc.out, tc.in, n, problems)
+		}
+	}
+	persisted.HostMap[from].Recon.Error = "connection refused"
+	persisted.HostMap[from].Recon.Reachable = false
+	problems := strings.Join(persisted.linkProblems(from, to, true, true), "\n")
+	if !strings.Contains(problems, "recon port of "+from+" failed our probe") {
+		t.Errorf("reverse direction's failed probe missing: %v", problems)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// A server whose stats page works but whose recon port is firewalled is no
+// use to the mesh.  So we connect to the recon port and go through the
+// opening config exchange, then tell the far end that the config failed, so
+// that no sync is ever started.
+//
+// On the wire, each message is a 4-byte big-endian length, covering a type
+// byte and the body.  Strings are a 4-byte length and the bytes.  The config
+// message body is a count of key/value string pairs.  Once both sides have
+// sent config, each sends the string "passed", or "failed" and a reason.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	reconMsgTypeConfig = 10
+	reconMaxMessage    = 64 * 1024
+
+	reconProbeVersion    = "1.1.6"
+	reconProbeBitquantum = "2"
+	reconProbeMbar       = "5"
+	reconProbeFilters    = "yminsky.dedup,yminsky.merge"
+	reconProbeFailReason = "sks_spider monitoring probe, not syncing"
+)
+
+type ReconProbe struct {
+	Port      int
+	IP        string
+	Reachable bool   // TCP connection made
+	Refused   bool   `json:",omitempty"` // connected, but no config exchange
+	Error     string `json:",omitempty"`
+	Config    map[string]string
+	Verdict   string `json:",omitempty"` // what the far end made of our config
+	Latency   time.Duration
+}
+
+// OK is whether the far end talks recon to us.  SKS and Hockeypuck drop
+// recon connections from anyone not a configured peer, which the spider never
+// is, so a Refused probe is the usual outcome for a healthy server.
+func (rp *ReconProbe) OK() bool {
+	return rp != nil && rp.Reachable && rp.Config != nil
+}
+
+func (rp *ReconProbe) String() string {
+	switch {
+	case rp == nil:
+		return "not probed"
+	case !rp.Reachable:
+		return fmt.Sprintf("port %d unreachable: %s", rp.Port, rp.Error)
+	case rp.Refused:
+		return fmt.Sprintf("port %d reachable, config exchange refused: %s", rp.Port, rp.Error)
+	case rp.Config == nil:
+		return fmt.Sprintf("port %d connected, no config exchange: %s", rp.Port, rp.Error)
+	}
+	return fmt.Sprintf("port %d ok in %s, version %s, filters %s",
+		rp.Port, rp.Latency.Round(time.Millisecond), rp.Config["version"], rp.Config["filters"])
+}
+
+func writeReconString(w io.Writer, s string) error {
+	buf := make([]byte, 4, 4+len(s))
+	binary.BigEndian.PutUint32(buf, uint32(len(s)))
+	_, err := w.Write(append(buf, s...))
+	return err
+}
+
+func readReconString(r io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if length > reconMaxMessage {
+		return "", fmt.Errorf("recon string length %d too large", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// writeReconConfig sends a config message; keys are written in the order
+// given, as SKS does.
+func writeReconConfig(w io.Writer, pairs [][2]string) error {
+	var body bytes.Buffer
+	body.WriteByte(reconMsgTypeConfig)
+	binary.Write(&body, binary.BigEndian, uint32(len(pairs)))
+	for _, kv := range pairs {
+		writeReconString(&body, kv[0])
+		writeReconString(&body, kv[1])
+	}
+	msg := make([]byte, 4, 4+body.Len())
+	binary.BigEndian.PutUint32(msg, uint32(body.Len()))
+	_, err := w.Write(append(msg, body.Bytes()...))
+	return err
+}
+
+func readReconConfig(r io.Reader) (map[string]string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 1 || length > reconMaxMessage {
+		return nil, fmt.Errorf("recon message length %d out of range", length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	if msg[0] != reconMsgTypeConfig {
+		return nil, fmt.Errorf("expected config message, got type %d", msg[0])
+	}
+	body := bytes.NewReader(msg[1:])
+	var count uint32
+	if err := binary.Read(body, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	config := make(map[string]string, count)
+	for i := uint32(0); i < count; i++ {
+		key, err := readReconString(body)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readReconString(body)
+		if err != nil {
+			return nil, err
+		}
+		config[key] = value
+	}
+	return config, nil
+}
+
+// reconExchange runs our half of the config exchange on an open connection,
+// filling in Config and Verdict.
+func reconExchange(conn net.Conn, probe *ReconProbe, timeout time.Duration) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	ours := [][2]string{
+		{"version", reconProbeVersion},
+		{"http port", strconv.Itoa(*flSksPortHkp)},
+		{"bitquantum", reconProbeBitquantum},
+		{"mbar", reconProbeMbar},
+		{"filters", reconProbeFilters},
+	}
+	// Both sides send config at once; don't rely on buffering to avoid a
+	// deadlock.
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- writeReconConfig(conn, ours) }()
+	config, err := readReconConfig(conn)
+	if err != nil {
+		return err
+	}
+	if err = <-writeErr; err != nil {
+		return err
+	}
+	probe.Config = config
+
+	var writing sync.WaitGroup
+	writing.Add(1)
+	go func() {
+		defer writing.Done()
+		if writeReconString(conn, "failed") == nil {
+			writeReconString(conn, reconProbeFailReason)
+		}
+	}()
+	verdict, err := readReconString(conn)
+	if err == nil && verdict == "failed" {
+		if reason, err2 := readReconString(conn); err2 == nil {
+			verdict += ": " + reason
+		}
+	}
+	probe.Verdict = verdict
+	writing.Wait()
+	return nil
+}
+
+func probeRecon(ip string, port int) *ReconProbe {
+	probe := &ReconProbe{IP: ip, Port: port}
+	if reason := ipRefusedReason(ip); reason != "" {
+		probe.Error = "refusing to connect: " + reason
+		return probe
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), *flReconProbeTimeout)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer conn.Close()
+	reconProbeConn(conn, probe)
+	probe.Latency = time.Since(start)
+	return probe
+}
+
+// reconProbeConn runs the exchange on a connection made to the recon port; a
+// failure before we have the far end's config counts as a refusal.
+func reconProbeConn(conn net.Conn, probe *ReconProbe) {
+	probe.Reachable = true
+	if err := reconExchange(conn, probe, *flReconProbeTimeout); err != nil {
+		probe.Error = err.Error()
+		probe.Refused = probe.Config == nil
+	}
+}
+
+// reconPortOf is what the server says, else what its peers use, else the
+// default.
+func reconPortOf(node *SksNode, views map[int][]string) int {
+	if node.AdvertisedReconPort != 0 {
+		return node.AdvertisedReconPort
+	}
+	best, bestCount := *flSksPortRecon, 0
+	for port, origins := range views {
+		if len(origins) > bestCount {
+			best, bestCount = port, len(origins)
+		}
+	}
+	return best
+}
+
+// ProbeReconPorts fills in Recon for every working host, trying the first
+// address which answered over HTTP.
+func ProbeReconPorts(hostMap HostMap, aliasMap AliasMap) {
+	views := peerReconPortViews(hostMap, aliasMap)
+	limit := make(chan bool, *flIPProbeParallel)
+	var probing sync.WaitGroup
+	var lock sync.Mutex
+	count, refused, failed := 0, 0, 0
+	for hostname, node := range hostMap {
+		ips := node.AnsweringIPs()
+		if node.AnalyzeError != "" || len(ips) == 0 {
+			continue
+		}
+		probing.Add(1)
+		limit <- true
+		go func(node *SksNode, ip string, port int) {
+			defer func() { <-limit; probing.Done() }()
+			probe := probeRecon(ip, port)
+			lock.Lock()
+			defer lock.Unlock()
+			node.Recon = probe
+			count++
+			switch {
+			case probe.Refused:
+				refused++
+			case !probe.OK():
+				failed++
+				Log.Printf("Recon probe of \"%s\": %s", node.Hostname, probe)
+			}
+		}(node, ips[0], reconPortOf(node, views[hostname]))
+	}
+	probing.Wait()
+	Log.Printf("Probed %d recon ports, %d refused config exchange, %d failed", count, refused, failed)
+}
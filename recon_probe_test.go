@@ -0,0 +1,91 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconExchange(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	serverSaw := make(chan string, 3)
+	go func() {
+		defer server.Close()
+		wrote := make(chan error, 1)
+		go func() {
+			wrote <- writeReconConfig(server, [][2]string{
+				{"version", "1.1.6"},
+				{"http port", "11371"},
+				{"bitquantum", "2"},
+				{"mbar", "5"},
+				{"filters", "yminsky.dedup,yminsky.merge"},
+			})
+		}()
+		config, err := readReconConfig(server)
+		if err != nil {
+			serverSaw <- "error: " + err.Error()
+			return
+		}
+		serverSaw <- config["filters"]
+		<-wrote
+		go func() { wrote <- writeReconString(server, "passed") }()
+		status, _ := readReconString(server)
+		reason, _ := readReconString(server)
+		serverSaw <- status
+		serverSaw <- reason
+		<-wrote
+	}()
+
+	probe := &ReconProbe{Port: 11370}
+	if err := reconExchange(client, probe, 5*time.Second); err != nil {
+		t.Fatalf("reconExchange failed: %s", err)
+	}
+	if probe.Config["version"] != "1.1.6" || probe.Config["http port"] != "11371" || probe.Verdict != "passed" {
+		t.Fatalf("Unexpected probe result: config %v, verdict %q", probe.Config, probe.Verdict)
+	}
+	for _, expected := range []string{reconProbeFilters, "failed", reconProbeFailReason} {
+		if got := <-serverSaw; got != expected {
+			t.Fatalf("Server saw %q, expected %q", got, expected)
+		}
+	}
+}
+
+func TestReconProbeRefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	// Not a configured peer: the server hangs up without sending config.
+	server.Close()
+
+	probe := &ReconProbe{IP: "192.0.2.1", Port: 11370}
+	reconProbeConn(client, probe)
+	if !probe.Reachable || !probe.Refused || probe.OK() {
+		t.Fatalf("Expected a reachable, refused probe, got %+v", probe)
+	}
+	if s := probe.String(); !strings.Contains(s, "config exchange refused") {
+		t.Errorf("Refusal described as %q", s)
+	}
+
+	unreachable := &ReconProbe{Port: 11370, Error: "connection refused"}
+	if unreachable.Refused || !strings.Contains(unreachable.String(), "unreachable") {
+		t.Errorf("Unreachable probe described as %q", unreachable)
+	}
+}
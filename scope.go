@@ -0,0 +1,184 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// How far a spidering run may reach.  By default it follows every gossip peer
+// to any depth; operators can cap the distance and the number of hosts, and
+// restrict the domains, for targeted scans of part of the mesh.
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	SkipExcluded    = "excluded"
+	SkipIPAddress   = "ip-address"
+	SkipUnqualified = "unqualified"
+	SkipMaxDepth    = "max-depth"
+	SkipMaxHosts    = "max-hosts"
+	SkipNotAllowed  = "not-allowed"
+	SkipDenied      = "denied"
+	SkipDisallowed  = "disallowed-address"
+)
+
+// domainMatcher is either a domain suffix, matching the domain and anything
+// under it, or a regular expression given as "~regexp".
+type domainMatcher struct {
+	suffix string
+	re     *regexp.Regexp
+}
+
+func (dm *domainMatcher) String() string {
+	if dm.re != nil {
+		return "~" + dm.re.String()
+	}
+	return dm.suffix
+}
+
+func (dm *domainMatcher) Match(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if dm.re != nil {
+		return dm.re.MatchString(hostname)
+	}
+	return hostname == dm.suffix || strings.HasSuffix(hostname, "."+dm.suffix)
+}
+
+// parseDomainList handles "example.org,~^keys[0-9]*\.": since the list is
+// comma-separated, a regexp can't contain a comma.
+func parseDomainList(spec string) ([]*domainMatcher, error) {
+	var matchers []*domainMatcher
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.HasPrefix(item, "~") {
+			re, err := regexp.Compile(item[1:])
+			if err != nil {
+				return nil, fmt.Errorf("bad domain regexp %q: %s", item, err)
+			}
+			matchers = append(matchers, &domainMatcher{re: re})
+			continue
+		}
+		suffix := strings.ToLower(strings.Trim(item, "."))
+		if suffix == "" {
+			return nil, fmt.Errorf("empty domain in %q", item)
+		}
+		matchers = append(matchers, &domainMatcher{suffix: suffix})
+	}
+	return matchers, nil
+}
+
+type ScopePolicy struct {
+	MaxDepth int // negative for no limit
+	MaxHosts int // zero for no limit
+	allow    []*domainMatcher
+	deny     []*domainMatcher
+}
+
+func NewScopePolicy(maxDepth, maxHosts int, allow, deny string) (*ScopePolicy, error) {
+	var err error
+	scope := &ScopePolicy{MaxDepth: maxDepth, MaxHosts: maxHosts}
+	if scope.allow, err = parseDomainList(allow); err != nil {
+		return nil, err
+	}
+	if scope.deny, err = parseDomainList(deny); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+// configuredScope is the scope from our flags, which were checked at start-up.
+func configuredScope() *ScopePolicy {
+	scope, err := NewScopePolicy(*flSpiderMaxDepth, *flSpiderMaxHosts, *flSpiderAllowDomain, *flSpiderDenyDomain)
+	if err != nil {
+		Log.Printf("Bad spider scope, not limiting: %s", err)
+		return &ScopePolicy{MaxDepth: -1}
+	}
+	return scope
+}
+
+// domainSkipReason says why the hostname is out of scope, or "" if it's in.
+func (scope *ScopePolicy) domainSkipReason(hostname string) string {
+	for _, dm := range scope.deny {
+		if dm.Match(hostname) {
+			return fmt.Sprintf("%s: matches %s", SkipDenied, dm)
+		}
+	}
+	if len(scope.allow) == 0 {
+		return ""
+	}
+	for _, dm := range scope.allow {
+		if dm.Match(hostname) {
+			return ""
+		}
+	}
+	return SkipNotAllowed
+}
+
+type SkippedHost struct {
+	Hostname string
+	Distance int
+	Reason   string
+}
+
+// skipReason is why we won't look at a host at all, or "" to go ahead.
+// Hosts we've already seen are skipped silently, rather than reported.
+func (spider *Spider) skipReason(hostname string, distance int) (reason string, report bool) {
+	if spider.considering[hostname] || spider.badDNS[hostname] {
+		return "seen", false
+	}
+	if _, ok := spider.knownHosts[hostname]; ok {
+		return "seen", false
+	}
+	if rule := spider.exclusions.ExcludeHost(hostname); rule != nil {
+		return fmt.Sprintf("%s: %s", SkipExcluded, rule), true
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		return SkipIPAddress, true
+	}
+	if !strings.Contains(hostname, ".") {
+		return SkipUnqualified, true
+	}
+	if reason := spider.scope.domainSkipReason(hostname); reason != "" {
+		return reason, true
+	}
+	if spider.scope.MaxDepth >= 0 && distance > spider.scope.MaxDepth {
+		return fmt.Sprintf("%s: distance %d beyond %d", SkipMaxDepth, distance, spider.scope.MaxDepth), true
+	}
+	if spider.scope.MaxHosts > 0 && len(spider.considering) >= spider.scope.MaxHosts {
+		return fmt.Sprintf("%s: already have %d", SkipMaxHosts, spider.scope.MaxHosts), true
+	}
+	return "", false
+}
+
+// SkippedReport lists the hosts left out of this run and why; only call
+// after Wait().
+func (spider *Spider) SkippedReport() []*SkippedHost {
+	report := make([]*SkippedHost, 0, len(spider.skipped))
+	for _, sh := range spider.skipped {
+		report = append(report, sh)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return hostCompare(report[i].Hostname, report[j].Hostname) < 0
+	})
+	return report
+}
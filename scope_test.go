@@ -0,0 +1,70 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScopeSkipReasons(t *testing.T) {
+	if _, err := NewScopePolicy(-1, 0, "~keys(", ""); err == nil {
+		t.Fatal("Bad regexp accepted in allow list")
+	}
+	scope, err := NewScopePolicy(2, 3, "example.org,~^keys[0-9]*\\.example\\.net$", "bad.example.org")
+	if err != nil {
+		t.Fatalf("NewScopePolicy failed: %s", err)
+	}
+	spider := &Spider{
+		considering: map[string]bool{"seen.example.org": true},
+		badDNS:      map[string]bool{},
+		knownHosts:  map[string]string{},
+		scope:       scope,
+		exclusions:  GetExclusionPolicy(),
+	}
+
+	for _, tc := range []struct {
+		hostname string
+		distance int
+		reason   string
+		report   bool
+	}{
+		{"seen.example.org", 0, "seen", false},
+		{"keys.example.org", 1, "", false},
+		{"EXAMPLE.ORG.", 1, "", false},
+		{"keys2.example.net", 1, "", false},
+		{"www.example.net", 1, SkipNotAllowed, true},
+		{"notexample.org", 1, SkipNotAllowed, true},
+		{"host.bad.example.org", 1, SkipDenied, true},
+		{"far.example.org", 3, SkipMaxDepth, true},
+		{"192.0.2.1", 0, SkipIPAddress, true},
+		{"localhost", 0, SkipExcluded, true},
+		{"localhost2", 0, SkipUnqualified, true},
+		{"pool.example.org", 0, SkipExcluded, true},
+	} {
+		reason, report := spider.skipReason(tc.hostname, tc.distance)
+		if !strings.HasPrefix(reason, tc.reason) || (tc.reason == "" && reason != "") || report != tc.report {
+			t.Errorf("skipReason(%q, %d) = %q, %v; expected %q, %v", tc.hostname, tc.distance, reason, report, tc.reason, tc.report)
+		}
+	}
+
+	spider.considering["a.example.org"] = true
+	spider.considering["b.example.org"] = true
+	if reason, _ := spider.skipReason("c.example.org", 1); !strings.HasPrefix(reason, SkipMaxHosts) {
+		t.Errorf("Host cap not applied, got reason %q", reason)
+	}
+}
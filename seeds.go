@@ -0,0 +1,184 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// Where a spidering run starts from.  The -spider-start-host is always a seed;
+// more can be given, and the membership file can supply them too.  If none of
+// the seeds we were told to use works out, we fall back to the membership
+// file rather than presenting an empty mesh.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SeedSourceStartHost  = "start-host"
+	SeedSourceFlag       = "seed-hosts"
+	SeedSourceMembership = "membership"
+	SeedSourceFallback   = "membership-fallback"
+)
+
+type SeedHost struct {
+	Hostname string
+	Distance int
+	Source   string
+}
+
+type SeedStatus struct {
+	SeedHost
+	Canonical string `json:",omitempty"`
+	Usable    bool
+	Problem   string `json:",omitempty"`
+}
+
+// parseSeedHosts handles "host1,host2=1,host3": an optional =N sets the
+// distance which the seed is given.  Seeds are hostnames only, as the port is
+// learnt from the peers; a host given twice keeps its shortest distance.
+func parseSeedHosts(spec string) ([]SeedHost, error) {
+	var seeds []SeedHost
+	index := make(map[string]int)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		seed := SeedHost{Hostname: item, Source: SeedSourceFlag}
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			d, err := strconv.Atoi(item[i+1:])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("bad seed distance in %q", item)
+			}
+			seed.Hostname = item[:i]
+			seed.Distance = d
+		}
+		if !membershipHostnameRe.MatchString(seed.Hostname) {
+			return nil, fmt.Errorf("bad seed hostname in %q (no port allowed)", item)
+		}
+		key := strings.ToLower(strings.TrimSuffix(seed.Hostname, "."))
+		if i, seen := index[key]; seen {
+			if seed.Distance < seeds[i].Distance {
+				seeds[i].Distance = seed.Distance
+			}
+			continue
+		}
+		index[key] = len(seeds)
+		seeds = append(seeds, seed)
+	}
+	return seeds, nil
+}
+
+func membershipSeeds(source string) ([]SeedHost, error) {
+	mf, err := LoadMembershipFile(*flSksMembershipFile)
+	if err != nil {
+		return nil, err
+	}
+	entries := mf.Entries()
+	seeds := make([]SeedHost, 0, len(entries))
+	for _, entry := range entries {
+		seeds = append(seeds, SeedHost{Hostname: entry.Host, Distance: *flSeedMemberDistance, Source: source})
+	}
+	return seeds, nil
+}
+
+// configuredSeeds is the seed list from our flags; the flag syntax is checked
+// at start-up, so errors here are only from reading the membership file.
+func configuredSeeds() []SeedHost {
+	seeds := []SeedHost{{Hostname: *flSpiderStartHost, Source: SeedSourceStartHost}}
+	if extra, err := parseSeedHosts(*flSpiderSeedHosts); err == nil {
+		seeds = append(seeds, extra...)
+	}
+	if *flSeedFromMembership {
+		members, err := membershipSeeds(SeedSourceMembership)
+		if err != nil {
+			Log.Printf("Unable to seed from membership file: %s", err)
+		}
+		seeds = append(seeds, members...)
+	}
+	return seeds
+}
+
+func (spider *Spider) AddSeeds(seeds []SeedHost) {
+	spider.seeds = append(spider.seeds, seeds...)
+	for _, seed := range seeds {
+		spider.AddHost(seed.Hostname, seed.Distance)
+	}
+}
+
+// SeedReport says what became of each seed; only call after Wait().
+func (spider *Spider) SeedReport() []*SeedStatus {
+	report := make([]*SeedStatus, 0, len(spider.seeds))
+	for _, seed := range spider.seeds {
+		status := &SeedStatus{SeedHost: seed}
+		report = append(report, status)
+		canonical, known := spider.knownHosts[seed.Hostname]
+		switch {
+		case spider.badDNS[seed.Hostname]:
+			status.Problem = "DNS resolution failed or disallowed"
+		case !known:
+			status.Problem = "skipped"
+		case spider.queryErrors[canonical] != nil:
+			status.Canonical = canonical
+			status.Problem = spider.queryErrors[canonical].Error()
+		case spider.queryErrors[seed.Hostname] != nil:
+			status.Canonical = canonical
+			status.Problem = spider.queryErrors[seed.Hostname].Error()
+		case spider.serverInfos[canonical] == nil:
+			status.Canonical = canonical
+			status.Problem = "no stats retrieved"
+		case spider.serverInfos[canonical].analyzeError != nil:
+			status.Canonical = canonical
+			status.Problem = spider.serverInfos[canonical].analyzeError.Error()
+		case spider.serverInfos[canonical].AnalyzeError != "":
+			status.Canonical = canonical
+			status.Problem = spider.serverInfos[canonical].AnalyzeError
+		default:
+			status.Canonical = canonical
+			status.Usable = true
+		}
+	}
+	return report
+}
+
+func (spider *Spider) anySeedUsable() bool {
+	for _, status := range spider.SeedReport() {
+		if status.Usable {
+			return true
+		}
+	}
+	return false
+}
+
+// spiderFromSeeds runs one complete spidering pass from our seeds, falling
+// back to the membership file if every seed is unusable.  The caller is still
+// responsible for the Terminate().
+func spiderFromSeeds(spider *Spider) {
+	spider.AddSeeds(configuredSeeds())
+	spider.Wait()
+	if spider.anySeedUsable() || *flSeedFromMembership {
+		return
+	}
+	Log.Printf("No seed host was usable, falling back to membership file \"%s\"", *flSksMembershipFile)
+	fallback, err := membershipSeeds(SeedSourceFallback)
+	if err != nil {
+		Log.Printf("Membership fallback failed: %s", err)
+		return
+	}
+	spider.AddSeeds(fallback)
+	spider.Wait()
+}
@@ -0,0 +1,135 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSeedHosts(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		seeds []SeedHost
+		bad   bool
+	}{
+		{"", nil, false},
+		{" , ,", nil, false},
+		{"a.example.org", []SeedHost{{"a.example.org", 0, SeedSourceFlag}}, false},
+		{"a.example.org, b.example.org=2,c.example.org=0", []SeedHost{
+			{"a.example.org", 0, SeedSourceFlag},
+			{"b.example.org", 2, SeedSourceFlag},
+			{"c.example.org", 0, SeedSourceFlag},
+		}, false},
+		{"a.example.org=3,b.example.org,A.Example.ORG.=1,a.example.org=2", []SeedHost{
+			{"a.example.org", 1, SeedSourceFlag},
+			{"b.example.org", 0, SeedSourceFlag},
+		}, false},
+		{"a.example.org=-1", nil, true},
+		{"a.example.org=x", nil, true},
+		{"a.example.org=", nil, true},
+		{"=2", nil, true},
+		{"a.example.org:11370", nil, true},
+		{"a.example.org:11370=1", nil, true},
+		{"[2001:db8::5]:11370", nil, true},
+		{"bad_host!.example.org", nil, true},
+	} {
+		seeds, err := parseSeedHosts(tc.spec)
+		if tc.bad {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.spec, seeds)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.spec, err)
+			continue
+		}
+		if len(seeds) != len(tc.seeds) {
+			t.Errorf("%q: got %v, expected %v", tc.spec, seeds, tc.seeds)
+			continue
+		}
+		for i := range seeds {
+			if seeds[i] != tc.seeds[i] {
+				t.Errorf("%q: seed %d is %+v, expected %+v", tc.spec, i, seeds[i], tc.seeds[i])
+			}
+		}
+	}
+}
+
+func TestSeedReport(t *testing.T) {
+	spider := &Spider{
+		badDNS: map[string]bool{"nxdomain.example.org": true},
+		knownHosts: map[string]string{
+			"good.example.org":    "good.example.org",
+			"www.good.example":    "good.example.org",
+			"refused.example.org": "refused.example.org",
+			"nostats.example.org": "nostats.example.org",
+			"broken.example.org":  "broken.example.org",
+			"parsed.example.org":  "parsed.example.org",
+		},
+		queryErrors: map[string]error{"refused.example.org": errors.New("connection refused")},
+		serverInfos: map[string]*SksNode{
+			"good.example.org":   {Hostname: "good.example.org"},
+			"broken.example.org": {Hostname: "broken.example.org", AnalyzeError: "HTTP GET failure: 503"},
+			"parsed.example.org": {Hostname: "parsed.example.org", analyzeError: errors.New("no keycount")},
+		},
+	}
+	spider.seeds = []SeedHost{
+		{"good.example.org", 0, SeedSourceStartHost},
+		{"www.good.example", 1, SeedSourceFlag},
+		{"nxdomain.example.org", 0, SeedSourceFlag},
+		{"skipped.example.org", 0, SeedSourceFlag},
+		{"refused.example.org", 0, SeedSourceMembership},
+		{"nostats.example.org", 0, SeedSourceMembership},
+		{"broken.example.org", 0, SeedSourceFallback},
+		{"parsed.example.org", 0, SeedSourceFallback},
+	}
+
+	expect := []struct {
+		canonical string
+		usable    bool
+		problem   string
+	}{
+		{"good.example.org", true, ""},
+		{"good.example.org", true, ""},
+		{"", false, "DNS resolution failed or disallowed"},
+		{"", false, "skipped"},
+		{"refused.example.org", false, "connection refused"},
+		{"nostats.example.org", false, "no stats retrieved"},
+		{"broken.example.org", false, "HTTP GET failure: 503"},
+		{"parsed.example.org", false, "no keycount"},
+	}
+	report := spider.SeedReport()
+	if len(report) != len(expect) {
+		t.Fatalf("Got %d seed statuses, expected %d", len(report), len(expect))
+	}
+	for i, want := range expect {
+		got := report[i]
+		if got.SeedHost != spider.seeds[i] || got.Canonical != want.canonical || got.Usable != want.usable || got.Problem != want.problem {
+			t.Errorf("Seed %d: got %+v, expected %+v", i, *got, want)
+		}
+	}
+	if !spider.anySeedUsable() {
+		t.Error("anySeedUsable false with usable seeds")
+	}
+
+	spider.seeds = spider.seeds[2:]
+	if spider.anySeedUsable() {
+		t.Error("anySeedUsable true with no usable seeds")
+	}
+}
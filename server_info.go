@@ -21,7 +21,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -60,7 +59,31 @@ type SksNode struct {
 	AnalyzeError string
 	IpList       []string
 	Aliases      []string
-	Distance     int
+	// Hops from the nearest seed, following gossip links; MutualDistance only
+	// follows links configured on both sides.  -1 if unreachable.
+	Distance       int
+	MutualDistance int
+	// Ports as the server reports them, 0 if it doesn't
+	AdvertisedHkpPort   int      `json:",omitempty"`
+	AdvertisedReconPort int      `json:",omitempty"`
+	PortProblems        []string `json:",omitempty"`
+	// How the stats fetch went
+	Redirects []string      `json:",omitempty"`
+	Timings   *FetchTimings `json:",omitempty"`
+	// The recon port connected to and config exchanged; nil if never probed.
+	Recon *ReconProbe `json:",omitempty"`
+	// Canary key lookups, from each address
+	Lookups []*LookupProbe `json:",omitempty"`
+	// Each address fetched from separately; nil if never probed.
+	IPStatus map[string]*IPProbe `json:",omitempty"`
+	// Against the mesh reference and our memory of earlier scans
+	KeysBehind  int  `json:",omitempty"`
+	FrozenScans int  `json:",omitempty"`
+	SyncStalled bool `json:",omitempty"`
+	// New and updated keys per period, oldest first, as the server reports
+	DailyHistogram  []*HistogramEntry `json:",omitempty"`
+	HourlyHistogram []*HistogramEntry `json:",omitempty"`
+	NoUpdates       bool              `json:",omitempty"`
 }
 
 var initHTTPOnce sync.Once
@@ -70,6 +93,7 @@ var ourHTTPClient *http.Client
 func getHTTPClient() *http.Client {
 	initHTTPOnce.Do(func() {
 		ourTransport = &http.Transport{
+			DialContext:           vettedDialContext,
 			DisableKeepAlives:     true,
 			ResponseHeaderTimeout: *flHttpFetchTimeout,
 		}
@@ -133,6 +157,12 @@ func (sn *SksNode) Minimize() {
 }
 
 func (sn *SksNode) Fetch() error {
+	return sn.FetchFrom(nil)
+}
+
+// FetchFrom only connects to the given addresses, which the caller has
+// already vetted; with none, the hostname is resolved and vetted at connect.
+func (sn *SksNode) FetchFrom(ips []string) error {
 	sn.Normalize()
 
 	req, err := http.NewRequest("GET", sn.uri, nil)
@@ -142,9 +172,22 @@ func (sn *SksNode) Fetch() error {
 	// allow more time for whole context than for HTTP headers, hanoi stacking
 	ctx, cancel := context.WithTimeout(context.Background(), *flHttpFetchTimeout+2*time.Second)
 	defer cancel()
+	if len(ips) > 0 {
+		ctx = withVettedIPs(ctx, sn.Hostname, ips)
+	}
+	start := time.Now()
+	sn.Timings = &FetchTimings{}
+	finished := func() {
+		if sn.Timings.Total == 0 {
+			sn.Timings.Total = time.Since(start)
+		}
+	}
+	defer finished()
+	ctx = withFetchTimings(ctx, start, sn.Timings)
 	req = req.WithContext(ctx)
 	req.Header.Set("User-Agent", "sks_peers/0.2 (SKS mesh spidering)")
-	cl := getHTTPClient()
+	cl := *getHTTPClient()
+	cl.CheckRedirect = sn.checkRedirectFor
 
 	resp, err := cl.Do(req)
 	if err != nil {
@@ -157,16 +200,25 @@ func (sn *SksNode) Fetch() error {
 	sn.ServerHeader = resp.Header.Get("Server")
 	sn.ViaHeader = resp.Header.Get("Via")
 	//doc, err := ehtml.Parse(resp.Body)
-	buf, err := ioutil.ReadAll(resp.Body)
+	buf, err := readBoundedBody(resp.Body, *flHttpMaxBody)
+	finished()
 	if err != nil {
 		return err
 	}
-	// try unmarshaling as JSON first
-	var foo map[string]interface{}
-	err = json.Unmarshal([]byte(buf), &foo)
-	if err == nil {
-		sn.pageJson = foo
-		return nil
+	kind, err := classifyContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if kind != bodyHTML {
+		var foo map[string]interface{}
+		err = json.Unmarshal(buf, &foo)
+		if err == nil {
+			sn.pageJson = foo
+			return nil
+		}
+		if kind == bodyJSON {
+			return fmt.Errorf("bad JSON body: %s", err)
+		}
 	}
 	// otherwise assume it's an SKS-style HTML page
 	doc, err := htmlp.Parse(buf, htmlp.DefaultEncodingBytes, nil, htmlp.DefaultParseOption, htmlp.DefaultEncodingBytes)
@@ -280,6 +332,9 @@ func (sn *SksNode) Analyze() {
 			}
 		}
 
+		sn.DailyHistogram = parseHistogramJson(sn.pageJson["daily"])
+		sn.HourlyHistogram = parseHistogramJson(sn.pageJson["hourly"])
+
 		if peerArray, ok := sn.pageJson["peers"].([]interface{}); ok == true {
 			sn.GossipPeers = make(map[string]string, len(peerArray))
 			sn.GossipPeerList = make([]string, len(peerArray))
@@ -318,6 +373,13 @@ func (sn *SksNode) Analyze() {
 			}
 		}
 
+		if daily, err := sn.histogramFromTable("Daily Histogram"); err == nil {
+			sn.DailyHistogram = daily
+		}
+		if hourly, err := sn.histogramFromTable("Hourly Histogram"); err == nil {
+			sn.HourlyHistogram = hourly
+		}
+
 		if peers, err := sn.dictFromPlainRows("Gossip Peers"); err == nil {
 			sn.GossipPeerList = make([]string, len(peers))
 			var i = 0
@@ -336,6 +398,7 @@ func (sn *SksNode) Analyze() {
 
 	}
 
+	sn.AdvertisedHkpPort, sn.AdvertisedReconPort = advertisedPorts(sn.Settings)
 	sn.Minimize()
 }
 
@@ -347,6 +410,15 @@ func (sn *SksNode) Url() string {
 	return fmt.Sprintf("http://%s:%d/pks/lookup?op=stats&options=mr", sn.Hostname, sn.Port)
 }
 
+// SoftwareName is the name of the keyserver software, defaulting to SKS for
+// those older servers which don't say.
+func (sn *SksNode) SoftwareName() string {
+	if sn.Software != "" {
+		return sn.Software
+	}
+	return defaultSoftware
+}
+
 func NodeUrl(name string, sn *SksNode) string {
 	if sn != nil {
 		return sn.Url()
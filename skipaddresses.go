@@ -16,63 +16,193 @@
 
 package sks_spider
 
-import "net"
+// Addresses we won't talk to.  The IANA special-purpose address registries
+// say which blocks are "globally reachable"; we refuse any address whose most
+// specific registry entry says it isn't.  To refresh, fetch the CSV files from
+// https://www.iana.org/assignments/iana-ipv4-special-registry/ and
+// https://www.iana.org/assignments/iana-ipv6-special-registry/ into data/.
+// Our own supplementary blocks, and any the operator adds, are never
+// reachable, and win over any IANA entry saying otherwise.
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed data/iana-ipv4-special-registry-1.csv
+var ianaIPv4SpecialRegistry string
+
+//go:embed data/iana-ipv6-special-registry-1.csv
+var ianaIPv6SpecialRegistry string
+
+const (
+	AddressSourceIANA         = "IANA"
+	AddressSourceSupplemental = "supplemental"
+	AddressSourceOperator     = "operator"
+)
+
+type AddressRule struct {
+	Block     *net.IPNet
+	Name      string
+	Source    string
+	Reachable bool
+}
+
+func (ar *AddressRule) String() string {
+	return fmt.Sprintf("%s %s (%s)", ar.Block, ar.Name, ar.Source)
+}
+
+func (ar *AddressRule) prefixLen() int {
+	ones, _ := ar.Block.Mask.Size()
+	return ones
+}
+
+// Not in the special-purpose registries, but not somewhere to send SKS
+// traffic either.
+var supplementalDisallowedIPs = []struct{ cidr, name string }{
+	{"224.0.0.0/4", "Multicast"},
+	{"192.88.99.0/24", "6to4 relay anycast; should not be sending SKS traffic to this underlying IP"},
+	{"2002:c058:6301::/48", "6to4 relay anycast, IPv6-side"},
+	{"2001:10::/28", "Deprecated ORCHID"},
+	{"fec0::/10", "Deprecated site-local"},
+	{"fe00::/8", "Non-global scoped; the feXE::/16 blocks are nominally global, we skip them too for sanity"},
+	{"ff00::/8", "Multicast"},
+}
+
+// addressRules keeps our own denials first, then the IANA entries most-specific
+// first, so the first match is the one which counts.
+var addressRules []*AddressRule
 
 func init() {
 	prepDisallowedIPs()
 }
 
-var disallowedIPs []*net.IPNet
+var registryFootnoteRe = regexp.MustCompile(`\s*\[\d+\]`)
+
+func cleanRegistryField(field string) string {
+	return strings.TrimSpace(registryFootnoteRe.ReplaceAllString(field, ""))
+}
+
+// parseSpecialRegistry reads one of the IANA CSV files.  Entries which have
+// been terminated carry no flags and are skipped; a reachability of "N/A"
+// (6to4, Teredo) is taken as reachable, as those are real global addresses.
+func parseSpecialRegistry(text string) ([]*AddressRule, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 1 {
+		return nil, fmt.Errorf("empty registry")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	blockCol, ok1 := columns["Address Block"]
+	nameCol, ok2 := columns["Name"]
+	reachCol, ok3 := columns["Globally Reachable"]
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("registry lacks expected columns, have %v", records[0])
+	}
+
+	var rules []*AddressRule
+	for _, record := range records[1:] {
+		reachable := cleanRegistryField(record[reachCol])
+		if reachable == "" {
+			continue
+		}
+		for _, cidr := range strings.Split(record[blockCol], ",") {
+			_, block, err := net.ParseCIDR(cleanRegistryField(cidr))
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, &AddressRule{
+				Block:     block,
+				Name:      strings.TrimSpace(record[nameCol]),
+				Source:    AddressSourceIANA,
+				Reachable: reachable != "False",
+			})
+		}
+	}
+	return rules, nil
+}
+
+func sortAddressRules(rules []*AddressRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		iIANA, jIANA := rules[i].Source == AddressSourceIANA, rules[j].Source == AddressSourceIANA
+		if iIANA != jIANA {
+			return jIANA
+		}
+		return rules[i].prefixLen() > rules[j].prefixLen()
+	})
+}
 
-// RFC 5735 / BCP 153  Special Use IPv4 Addresses (DSUA)
-// RFC 5736 IANA IPv4  Special Purpose Address Registry
-// RFC 5737            IPv4 Address Blocks Reserved for Documentation
-// RFC 5156            Special-Use IPv6 Addresses
 func prepDisallowedIPs() {
-	list := make([]*net.IPNet, 0, 50)
-	for _, spec := range []string{
-		"0.0.0.0/8",          // DSUA this
-		"10.0.0.0/8",         // DSUA RFC1918
-		"127.0.0.0/8",        // DSUA loopback
-		"169.254.0.0/16",     // DSUA link-local
-		"172.16.0.0/12",      // DSUA RFC1918
-		"192.0.2.0/24",       // TEST-NET-1
-		"192.88.99.0/24",     // DSUA 6to4 anycast relay; should not be sending SKS traffic to this underlying IP
-		"192.168.0.0/16",     // DSUA RFC1918
-		"198.18.0.0/15",      // DSUA Benchmarking
-		"198.51.100.0/24",    // TEST-NET-2
-		"203.0.113.0/24",     // TEST-NET-3
-		"224.0.0.0/4",        // DSUA Class D Multicast
-		"240.0.0.0/4",        // DSUA Class E
-		"255.255.255.255/32", // DSUA Limited Broadcast
-
-		"192.0.0.0/29", // http://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry.xml
-
-		"2001:db8::/32",       // Documentation
-		"2001:10::/28",        // ORCHID
-		"2002:c058:6301::/48", // 6to4 anycast relay, IPv6-side
-		"fc00::/7",            // RFC 4193 unique local unicast addresses
-		"fe00::/8",            // various non-global scoped addresses
-		"ff00::/8",            // Multicast
-		"0100::/64",           // Blackhole / Discard prefix; RFC 6666
-		// ignore (permit): 6bone, 6to4, teredo
-		// For fe00::/8: the 16 feXE::/16 blocks are nominally global; we skip them too for sanity
-	} {
-		_, block, _ := net.ParseCIDR(spec)
-		list = append(list, block)
+	list := make([]*AddressRule, 0, 70)
+	for _, registry := range []string{ianaIPv4SpecialRegistry, ianaIPv6SpecialRegistry} {
+		rules, err := parseSpecialRegistry(registry)
+		if err != nil {
+			panic("embedded IANA registry broken: " + err.Error())
+		}
+		list = append(list, rules...)
+	}
+	for _, extra := range supplementalDisallowedIPs {
+		_, block, _ := net.ParseCIDR(extra.cidr)
+		list = append(list, &AddressRule{Block: block, Name: extra.name, Source: AddressSourceSupplemental})
 	}
-	disallowedIPs = list
+	sortAddressRules(list)
+	addressRules = list
 }
 
-func IPDisallowed(ipstr string) bool {
+// AddDisallowedIPs takes the operator's own comma-separated CIDR blocks; only
+// call before spidering starts.
+func AddDisallowedIPs(spec string) error {
+	list := append([]*AddressRule{}, addressRules...)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, block, err := net.ParseCIDR(item)
+		if err != nil {
+			return err
+		}
+		list = append(list, &AddressRule{Block: block, Name: "Operator-configured", Source: AddressSourceOperator})
+	}
+	sortAddressRules(list)
+	addressRules = list
+	return nil
+}
+
+// IPDisallowedReason gives the rule forbidding the address, or "" if it's
+// fine to contact.
+func IPDisallowedReason(ipstr string) string {
 	ip := net.ParseIP(ipstr)
 	if ip == nil {
-		return true
+		return "not an IP address"
 	}
-	for _, block := range disallowedIPs {
-		if block.Contains(ip) {
-			return true
+	isIPv4 := ip.To4() != nil
+	for _, rule := range addressRules {
+		// net.IPNet would match IPv4 against ::ffff:0:0/96
+		if isIPv4 != (len(rule.Block.Mask) == net.IPv4len) {
+			continue
+		}
+		if rule.Block.Contains(ip) {
+			if rule.Reachable {
+				return ""
+			}
+			return rule.String()
 		}
 	}
-	return false
+	return ""
+}
+
+func IPDisallowed(ipstr string) bool {
+	return IPDisallowedReason(ipstr) != ""
 }
@@ -17,6 +17,9 @@
 package sks_spider
 
 import (
+	"io/ioutil"
+	"log"
+	"strings"
 	"testing"
 )
 
@@ -33,10 +36,27 @@ func TestAddressSkip(t *testing.T) {
 		"192.0.2.42",
 		"241.2.3.4",
 		"2001:db8::1",
+		"100.64.1.1",
+		"192.0.0.170",
+		"224.0.0.251",
+		"64:ff9b:1::1",
+		"2001:4::1",
+		"fec0::1",
+		"fe80::1",
+		"::ffff:127.0.0.1",
+		"3fff::1",
+		"not-an-ip",
 	}
 	shouldBeAllowed := [...]string{
 		"172.32.0.0",
 		"2001:1db8::1",
+		"192.0.0.9",
+		"64:ff9b::c000:201",
+		"2001::1",
+		"2001:3::1",
+		"2002:102:304::1",
+		"8.8.8.8",
+		"2a00:1450::1",
 	}
 	for _, wantFail := range shouldBeRejected {
 		if !IPDisallowed(wantFail) {
@@ -48,4 +68,70 @@ func TestAddressSkip(t *testing.T) {
 			t.Fatalf("IP [%s] was rejected, should be clear for use", wantAllow)
 		}
 	}
+	if reason := IPDisallowedReason("100.64.1.1"); !strings.Contains(reason, "100.64.0.0/10") || !strings.Contains(reason, AddressSourceIANA) {
+		t.Fatalf("Reason for CGNAT rejection unhelpful: %q", reason)
+	}
+}
+
+func TestOperatorDisallowedIPs(t *testing.T) {
+	saved := addressRules
+	defer func() { addressRules = saved }()
+	if err := AddDisallowedIPs("8.8.8.0/24, 2a00:1450::/32"); err != nil {
+		t.Fatalf("AddDisallowedIPs failed: %s", err)
+	}
+	for _, ip := range []string{"8.8.8.8", "2a00:1450::1"} {
+		if reason := IPDisallowedReason(ip); !strings.Contains(reason, AddressSourceOperator) {
+			t.Fatalf("IP [%s] not rejected by operator rule, got %q", ip, reason)
+		}
+	}
+	if err := AddDisallowedIPs("8.8.8.8/33"); err == nil {
+		t.Fatal("Bad CIDR accepted")
+	}
+}
+
+// An operator block wider than an IANA "globally reachable" entry must still
+// win over it.
+func TestOperatorDisallowedOverridesReachable(t *testing.T) {
+	saved := addressRules
+	defer func() { addressRules = saved }()
+	for _, ip := range []string{"2001:3::1", "2001:4:112::1", "192.0.0.9", "192.0.0.10"} {
+		if IPDisallowed(ip) {
+			t.Fatalf("IP [%s] rejected before adding operator rules: %s", ip, IPDisallowedReason(ip))
+		}
+	}
+	if err := AddDisallowedIPs("2001::/16,192.0.0.0/24"); err != nil {
+		t.Fatalf("AddDisallowedIPs failed: %s", err)
+	}
+	for _, ip := range []string{"2001:3::1", "2001:4:112::1", "2001::1", "192.0.0.9", "192.0.0.10"} {
+		if reason := IPDisallowedReason(ip); !strings.Contains(reason, AddressSourceOperator) {
+			t.Errorf("IP [%s] not rejected by wider operator rule, got %q", ip, reason)
+		}
+	}
+	if IPDisallowed("192.0.1.1") || IPDisallowed("2002:102:304::1") {
+		t.Error("Operator rules rejected addresses outside their blocks")
+	}
+}
+
+func TestDisallowedAddressSkipped(t *testing.T) {
+	if Log == nil {
+		Log = log.New(ioutil.Discard, "", 0)
+	}
+	spider := &Spider{
+		badDNS:    make(map[string]bool),
+		skipped:   make(map[string]*SkippedHost),
+		distances: map[string]int{"cgnat.example.org": 2},
+	}
+	spider.processDnsResult(&DnsResult{hostname: "cgnat.example.org", ipList: []string{"100.64.1.1"}})
+	if !spider.badDNS["cgnat.example.org"] {
+		t.Fatal("Host with a disallowed address not marked bad")
+	}
+	skipped := spider.skipped["cgnat.example.org"]
+	if skipped == nil || skipped.Distance != 2 {
+		t.Fatalf("Host with a disallowed address not reported as skipped: %+v", skipped)
+	}
+	for _, want := range []string{SkipDisallowed, "[100.64.1.1]", "100.64.0.0/10", AddressSourceIANA} {
+		if !strings.Contains(skipped.Reason, want) {
+			t.Errorf("Skip reason %q lacks %q", skipped.Reason, want)
+		}
+	}
 }
@@ -75,6 +75,11 @@ type Spider struct {
 	pendingCountries map[string]int
 	distances        map[string]int
 	countriesForIPs  map[string]string
+	seeds            []SeedHost
+	scope            *ScopePolicy
+	exclusions       *ExclusionPolicy
+	skipped          map[string]*SkippedHost
+	reconHints       map[string]map[string]int // host -> peer listing it -> recon port
 	terminate        chan bool
 }
 
@@ -99,6 +104,10 @@ func StartSpider() *Spider {
 	spider.pendingCountries = make(map[string]int)
 	spider.distances = make(map[string]int)
 	spider.countriesForIPs = make(map[string]string)
+	spider.scope = configuredScope()
+	spider.exclusions = GetExclusionPolicy()
+	spider.skipped = make(map[string]*SkippedHost)
+	spider.reconHints = make(map[string]map[string]int)
 	spider.terminate = make(chan bool)
 
 	KillDummySpiderForDiagnosticsChannel()
@@ -175,7 +184,6 @@ func spiderMainLoop(spider *Spider) {
 }
 
 func (spider *Spider) considerHost(hostname string, request *HostsRequest) {
-	skip := false
 	distance := -1
 
 	if request.origin != "" {
@@ -191,41 +199,19 @@ func (spider *Spider) considerHost(hostname string, request *HostsRequest) {
 		spider.distances[hostname] = distance
 	}
 
-	if _, ok := spider.considering[hostname]; ok {
-		skip = true
-	} else if _, ok := BlacklistedHosts[hostname]; ok {
-		Log.Printf("Ignoring blacklisted host: \"%s\"", hostname)
-		skip = true
-	} else if _, ok := spider.badDNS[hostname]; ok {
-		skip = true
-	} else if _, ok := spider.knownHosts[hostname]; ok {
-		skip = true
-	} else if ip := net.ParseIP(hostname); ip != nil {
-		Log.Printf("Ignoring IP address: [%s]", hostname)
-		skip = true
-	} else if !strings.Contains(hostname, ".") {
-		Log.Printf("Ignoring unqualified hostname: %s", hostname)
-		skip = true
-	} else if strings.Contains(hostname, "pool.") {
-		Log.Printf("Ignoring pool hostname: %s", hostname)
-		skip = true
-	} else if strings.HasSuffix(hostname, ".local") {
-		Log.Printf("Ignoring .local hostname: %s", hostname)
-		skip = true
-	} else {
-		for _, hn := range blacklistedQueryHosts {
-			if hn != hostname {
-				continue
+	if reason, report := spider.skipReason(hostname, distance); reason != "" {
+		if report {
+			Log.Printf("Skipping host \"%s\": %s", hostname, reason)
+			if _, ok := spider.skipped[hostname]; !ok {
+				spider.skipped[hostname] = &SkippedHost{Hostname: hostname, Distance: distance, Reason: reason}
 			}
-			Log.Printf("Ignoring blacklisted hostname: %s", hostname)
-			skip = true
 		}
-	}
-	if skip {
 		spider.pendingHosts[hostname] -= 1
 		spider.pending.Done()
 		return
 	}
+	// might have been out of reach by an earlier, longer, path
+	delete(spider.skipped, hostname)
 
 	spider.considering[hostname] = true
 	spider.distances[hostname] = distance
@@ -268,9 +254,24 @@ func (spider *Spider) processDnsResult(dns *DnsResult) {
 	}
 	ipList := flattenIPs(dns.ipList)
 	for _, ip := range ipList {
-		if IPDisallowed(ip) {
-			Log.Printf("Disallowing host \"%s\" because of IP [%s]", hostname, ip)
+		if reason := IPDisallowedReason(ip); reason != "" {
+			Log.Printf("Disallowing host \"%s\" because of IP [%s]: %s", hostname, ip, reason)
+			spider.badDNS[hostname] = true
+			spider.skipped[hostname] = &SkippedHost{
+				Hostname: hostname,
+				Distance: spider.distances[hostname],
+				Reason:   fmt.Sprintf("%s: [%s] in %s", SkipDisallowed, ip, reason),
+			}
+			return
+		}
+		if rule := spider.exclusions.ExcludeIP(ip); rule != nil {
+			Log.Printf("Excluding host \"%s\" because of IP [%s]: %s", hostname, ip, rule)
 			spider.badDNS[hostname] = true
+			spider.skipped[hostname] = &SkippedHost{
+				Hostname: hostname,
+				Distance: spider.distances[hostname],
+				Reason:   fmt.Sprintf("%s: [%s] in %s", SkipExcluded, ip, rule),
+			}
 			return
 		}
 		canonical, ok := spider.knownIPs[ip]
@@ -300,32 +301,29 @@ func (spider *Spider) processDnsResult(dns *DnsResult) {
 	spider.serverInfos[hostname] = nil
 	spider.pending.Add(1)
 	spider.pendingHosts[hostname] += 1
-	go spider.shared.QueryHost(hostname)
+	go spider.shared.QueryHost(hostname, ipList, spider.hkpPortFor(hostname))
 }
 
-func (sResults *spiderShared) QueryHost(hostname string) {
-	node := &SksNode{Hostname: hostname}
-	err := node.Fetch()
+// QueryHost fetches stats from the host, connecting only to the addresses
+// we have just vetted.  If the port which the peers led us to doesn't work,
+// we fall back to the default.
+func (sResults *spiderShared) QueryHost(hostname string, ipList []string, port int) {
+	node := &SksNode{Hostname: hostname, Port: port}
+	err := node.FetchFrom(ipList)
+	if err != nil && port != *flSksPortHkp {
+		Log.Printf("Fetching \"%s\" on port %d failed, trying default port: %s", hostname, port, err)
+		node = &SksNode{Hostname: hostname}
+		err = node.FetchFrom(ipList)
+	}
 	if err != nil {
 		sResults.hostResult <- &HostResult{hostname: hostname, err: err}
 		return
 	}
-	var analyzePaniced bool = false
-	func() {
-		defer func() {
-			if x := recover(); x != nil {
-				e := fmt.Errorf("analyze panic: %v", x)
-				node.analyzeError = e
-				sResults.hostResult <- &HostResult{hostname: hostname, node: node, err: e}
-				analyzePaniced = true
-			}
-		}()
-		node.Analyze()
-	}()
-	if !analyzePaniced {
-		sResults.hostResult <- &HostResult{hostname: hostname, node: node}
+	if err = node.analyzeRecovering(); err != nil {
+		sResults.hostResult <- &HostResult{hostname: hostname, node: node, err: err}
+		return
 	}
-	return
+	sResults.hostResult <- &HostResult{hostname: hostname, node: node}
 }
 
 func (spider *Spider) processHostResult(hr *HostResult) {
@@ -367,7 +365,7 @@ func (spider *Spider) processHostResult(hr *HostResult) {
 			spider.ipsForHost[canonical] = flattenIPs(spider.ipsForHost[canonical], spider.ipsForHost[hostname])
 		}
 		delete(spider.aliasesForHost, hostname)
-		if old, ok3 := spider.distances[canonical]; !ok3 || old < spider.distances[hostname] {
+		if old, ok3 := spider.distances[canonical]; !ok3 || old > spider.distances[hostname] {
 			spider.distances[canonical] = spider.distances[hostname]
 		}
 	}
@@ -380,6 +378,7 @@ func (spider *Spider) processHostResult(hr *HostResult) {
 	}
 
 	spider.serverInfos[canonical] = node
+	spider.noteReconHints(canonical, node.GossipPeers)
 	spider.BatchAddHost(canonical, node.GossipPeerList)
 	return
 }
@@ -0,0 +1,93 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+// The spider vets the addresses a hostname resolves to before querying it;
+// if the HTTP client then resolved the name again for itself, a second,
+// different, answer would never be checked and we could be steered into
+// probing internal networks.  So we connect only to the addresses vetted,
+// passed down in the request context, while the URL (and so the Host header)
+// keeps the hostname.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type vettedAddrsKey struct{}
+
+type vettedAddrs struct {
+	host string
+	ips  []string
+}
+
+// withVettedIPs pins connections to the host to the given addresses.
+func withVettedIPs(ctx context.Context, host string, ips []string) context.Context {
+	return context.WithValue(ctx, vettedAddrsKey{}, &vettedAddrs{host: host, ips: ips})
+}
+
+// ipRefusedReason applies every address check the spider makes.
+func ipRefusedReason(ip string) string {
+	if reason := IPDisallowedReason(ip); reason != "" {
+		return reason
+	}
+	if rule := GetExclusionPolicy().ExcludeIP(ip); rule != nil {
+		return "excluded by policy: " + rule.String()
+	}
+	return ""
+}
+
+// vettedDialContext is the DialContext for our HTTP transport.  Hosts not
+// pinned in the context, such as the target of a redirect, are resolved
+// here and the answers checked the same way.
+func vettedDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	if pinned, ok := ctx.Value(vettedAddrsKey{}).(*vettedAddrs); ok && strings.EqualFold(pinned.host, host) {
+		ips = pinned.ips
+	} else if net.ParseIP(host) != nil {
+		ips = []string{host}
+	} else {
+		ips, err = net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		if reason := ipRefusedReason(ip); reason != "" {
+			lastErr = fmt.Errorf("refusing to connect to %s [%s]: %s", host, ip, reason)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses to connect to for %s", host)
+	}
+	return nil, lastErr
+}
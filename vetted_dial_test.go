@@ -0,0 +1,63 @@
+/*
+   Copyright 2026 Phil Pennock
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sks_spider
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVettedDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{DialContext: vettedDialContext}}
+	uri := "http://keys.example.invalid:" + port + "/"
+
+	ctx := withVettedIPs(context.Background(), "keys.example.invalid", []string{"127.0.0.1"})
+	req, _ := http.NewRequest("GET", uri, nil)
+	if _, err := client.Do(req.WithContext(ctx)); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("Connection to loopback not refused, err: %v", err)
+	}
+
+	saved := addressRules
+	defer func() { addressRules = saved }()
+	addressRules = nil
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Pinned fetch failed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "keys.example.invalid:"+port {
+		t.Fatalf("Host header not preserved, server saw %q", body)
+	}
+
+	// Other hosts, as from a redirect, don't get to use the pinned addresses.
+	other, _ := http.NewRequest("GET", "http://other.example.invalid:"+port+"/", nil)
+	if _, err := client.Do(other.WithContext(ctx)); err == nil {
+		t.Fatal("Unpinned host reached through pinned addresses")
+	}
+}